@@ -0,0 +1,307 @@
+package goof
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The timestamp added to the names of rotated files
+const rotateTimeFormat = "20060102-150405"
+
+// Settings for NewAppendWriter.  The zero value buffers 64KB, flushes every second, and never rotates.
+type AppendWriterOptions struct {
+	BufferSize    int           // Flush when this many bytes are waiting.  Default 64KB
+	FlushInterval time.Duration // Flush this often.  Default 1 second
+	MaxSize       int64         // Rotate before the file grows past this many bytes.  0 means no limit
+	RotateEvery   time.Duration // Rotate when the file has been open this long.  0 means never
+	Compress      bool          // Gzip rotated files
+	MaxBackups    int           // Delete the oldest rotated files, keeping this many.  0 keeps them all
+}
+
+// Appends to a file, keeping it open, and rotating it when it gets too big or too old.  It is safe to use from many
+// goroutines, and works as the output for the log package:
+//
+//	w, err := goof.NewAppendWriter("app.log", goof.AppendWriterOptions{MaxSize: 100 << 20, MaxBackups: 5, Compress: true})
+//	log.SetOutput(w)
+//	defer w.Close()
+//
+// Rotated files are renamed to path.YYYYMMDD-HHMMSS, with .gz added if they are compressed.
+type AppendWriter struct {
+	path string
+	opts AppendWriterOptions
+
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+	closed bool
+
+	stop         chan struct{}
+	flusherDone  chan struct{}
+	compressing  sync.WaitGroup
+	housekeeping sync.Mutex // Compress and prune one rotation at a time
+}
+
+// Open path for appending, creating it if needed
+func NewAppendWriter(path string, opts AppendWriterOptions) (*AppendWriter, error) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 64 * 1024
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	a := &AppendWriter{
+		path:        path,
+		opts:        opts,
+		stop:        make(chan struct{}),
+		flusherDone: make(chan struct{}),
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	go a.flusher()
+	return a, nil
+}
+
+func (a *AppendWriter) open() error {
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return WrapErrorf(err, "could not open %v", a.path)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return WrapErrorf(err, "could not check %v", a.path)
+	}
+	a.f, a.size, a.opened = f, info.Size(), time.Now()
+	if a.w == nil {
+		a.w = bufio.NewWriterSize(f, a.opts.BufferSize)
+	} else {
+		a.w.Reset(f)
+	}
+	return nil
+}
+
+// Flush and rotate in the background
+func (a *AppendWriter) flusher() {
+	defer close(a.flusherDone)
+	ticker := time.NewTicker(a.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			a.w.Flush()
+			if a.opts.RotateEvery > 0 && time.Since(a.opened) >= a.opts.RotateEvery && a.size > 0 {
+				a.rotate()
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// Append p to the file.  It is written when the buffer fills, at the next flush interval, or on Flush or Close.
+func (a *AppendWriter) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return 0, NewErrorf("%v is closed", a.path)
+	}
+	if a.size > 0 && (a.opts.MaxSize > 0 && a.size+int64(len(p)) > a.opts.MaxSize ||
+		a.opts.RotateEvery > 0 && time.Since(a.opened) >= a.opts.RotateEvery) {
+		if err := a.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := a.w.Write(p)
+	a.size += int64(n)
+	if err != nil {
+		return n, WrapErrorf(err, "could not write to %v", a.path)
+	}
+	return n, nil
+}
+
+// Write everything buffered to the file
+func (a *AppendWriter) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	if err := a.w.Flush(); err != nil {
+		return WrapErrorf(err, "could not write to %v", a.path)
+	}
+	return nil
+}
+
+// Move the current file aside, and start a new one
+func (a *AppendWriter) Rotate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return NewErrorf("%v is closed", a.path)
+	}
+	return a.rotate()
+}
+
+func (a *AppendWriter) rotate() error {
+	if err := a.w.Flush(); err != nil {
+		return WrapErrorf(err, "could not write to %v", a.path)
+	}
+	if err := a.f.Close(); err != nil {
+		return WrapErrorf(err, "could not close %v", a.path)
+	}
+
+	//Two rotations in the same second need different names
+	stamp := a.path + "." + time.Now().Format(rotateTimeFormat)
+	rotated := stamp
+	for i := 1; Exists(rotated) || Exists(rotated+".gz"); i++ {
+		rotated = stamp + "-" + strconv.Itoa(i)
+	}
+	renameErr := os.Rename(a.path, rotated)
+	if err := a.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return WrapErrorf(renameErr, "could not rotate %v", a.path)
+	}
+
+	a.compressing.Add(1)
+	go func() {
+		defer a.compressing.Done()
+		a.housekeeping.Lock()
+		defer a.housekeeping.Unlock()
+		if a.opts.Compress {
+			compressFile(rotated)
+		}
+		a.pruneBackups()
+	}()
+	return nil
+}
+
+// Gzip a file, and remove the original
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return WrapErrorf(err, "could not open %v", path)
+	}
+	defer in.Close()
+	out, err := OpenOutputAtomic(path+".gz", "gz")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Abort()
+		return WrapErrorf(err, "could not compress %v", path)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Delete the oldest rotated files, keeping MaxBackups of them
+func (a *AppendWriter) pruneBackups() {
+	if a.opts.MaxBackups <= 0 {
+		return
+	}
+	backups := a.backups()
+	if len(backups) <= a.opts.MaxBackups {
+		return
+	}
+	for _, b := range backups[:len(backups)-a.opts.MaxBackups] {
+		os.Remove(b)
+		os.Remove(b + ".gz")
+	}
+}
+
+// The rotated files, oldest first, without the .gz suffix
+func (a *AppendWriter) backups() []string {
+	dir, base := filepath.Split(a.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	type backup struct {
+		path    string
+		stamp   time.Time
+		counter int
+	}
+	found := map[string]backup{}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		//Files being compressed have both names, so count them once
+		rest := strings.TrimSuffix(name[len(base)+1:], ".gz")
+		if len(rest) < len(rotateTimeFormat) {
+			continue
+		}
+		stamp, err := time.Parse(rotateTimeFormat, rest[:len(rotateTimeFormat)])
+		if err != nil {
+			continue
+		}
+		counter := 0
+		if suffix := rest[len(rotateTimeFormat):]; suffix != "" {
+			counter, err = strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+			if err != nil || suffix[0] != '-' {
+				continue
+			}
+		}
+		found[rest] = backup{filepath.Join(dir, base+"."+rest), stamp, counter}
+	}
+
+	list := []backup{}
+	for _, b := range found {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].stamp.Equal(list[j].stamp) {
+			return list[i].stamp.Before(list[j].stamp)
+		}
+		return list[i].counter < list[j].counter
+	})
+	out := []string{}
+	for _, b := range list {
+		out = append(out, b.path)
+	}
+	return out
+}
+
+// Flush, close the file, and wait for rotated files to be compressed
+func (a *AppendWriter) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.stop)
+	err := a.w.Flush()
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	a.mu.Unlock()
+
+	<-a.flusherDone
+	a.compressing.Wait()
+	if err != nil {
+		return WrapErrorf(err, "could not close %v", a.path)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package goof
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// Files are copied in chunks this big, with a progress report after each one
+const copyChunkSize = 8 * 1024 * 1024
+
+// Settings for CopyFile, CopyTree and MoveFile.  The zero value copies permissions and modification times, and doesn't
+// report progress.
+type CopyOptions struct {
+	Progress     func(path string, copied, total int64) // Called with the source path when each file starts, and after each chunk.  total is the file's size
+	SkipMetadata bool                                   // Don't copy permissions and modification times.  New files get 0644, new directories 0755
+}
+
+// Copy a file to dst, replacing dst if it exists.  The copy is written to a temporary file, flushed to disk, and
+// renamed into place, so dst is never half-written.  A symlink is copied as a symlink.
+//
+// On Linux, the copy shares the data with the original where the filesystem supports it (reflinks, e.g. on btrfs and
+// XFS), and otherwise is done inside the kernel with copy_file_range.
+func CopyFile(src, dst string, opts CopyOptions) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return WrapErrorf(err, "could not read %v", src)
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return copySymlink(src, dst)
+	case info.IsDir():
+		return NewErrorf("could not copy %v, it is a directory", src)
+	}
+	return copyFile(src, dst, info, opts)
+}
+
+func copyFile(src, dst string, info os.FileInfo, opts CopyOptions) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return WrapErrorf(err, "could not open %v", src)
+	}
+	defer in.Close()
+	out, err := CreateAtomic(dst, 0644)
+	if err != nil {
+		return err
+	}
+	if !opts.SkipMetadata {
+		//CreateAtomic keeps the permissions of the file it replaces, but we want the source's
+		out.perm = info.Mode().Perm()
+	}
+	if err := copyContents(out.tmp, in, src, info.Size(), opts.Progress); err != nil {
+		out.Abort()
+		return WrapErrorf(err, "could not copy %v to %v", src, dst)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if opts.SkipMetadata {
+		return nil
+	}
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return WrapErrorf(err, "could not set the time on %v", dst)
+	}
+	return nil
+}
+
+// Copy in to out, a chunk at a time.  os.File uses copy_file_range (or the platform's equivalent) when it can, as long
+// as both ends are files.
+func copyContents(out, in *os.File, src string, size int64, progress func(string, int64, int64)) error {
+	if progress == nil {
+		progress = func(string, int64, int64) {}
+	}
+	progress(src, 0, size)
+	if cloneFile(out, in) {
+		progress(src, size, size)
+		return nil
+	}
+	var copied int64
+	for {
+		n, err := io.CopyN(out, in, copyChunkSize)
+		copied += n
+		if n > 0 {
+			progress(src, copied, size)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Copy a directory and everything in it to dst, which is created if it doesn't exist.  Symlinks are copied as
+// symlinks, not followed.  Files already in dst are replaced, and files that aren't in src are left alone.
+//
+// CopyTree stops at the first error, leaving whatever it had copied so far.
+func CopyTree(src, dst string, opts CopyOptions) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return WrapErrorf(err, "could not read %v", src)
+	}
+	if !info.IsDir() {
+		return NewErrorf("could not copy %v, it is not a directory", src)
+	}
+	entries, errs := Walk(src, WalkOptions{})
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	//Directories are made writable until everything is copied into them, then get their real permissions and times
+	if err := makeCopyDir(dst, info, opts); err != nil {
+		return err
+	}
+	dirs := []WalkEntry{{Path: src, Info: info}}
+	for _, e := range entries {
+		target := filepath.Join(dst, filepath.FromSlash(e.Rel))
+		mode := e.Info.Mode()
+		var err error
+		switch {
+		case mode.IsDir():
+			err = makeCopyDir(target, e.Info, opts)
+			dirs = append(dirs, e)
+		case mode&os.ModeSymlink != 0:
+			err = copySymlink(e.Path, target)
+		case mode.IsRegular():
+			err = copyFile(e.Path, target, e.Info, opts)
+		default:
+			err = NewErrorf("could not copy %v, it is not a file, directory or symlink", e.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	//Walk sorts parents before their children, so going backwards sets the deepest directories first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target := filepath.Join(dst, filepath.FromSlash(dirs[i].Rel))
+		if !opts.SkipMetadata {
+			if err := copyMetadata(target, dirs[i].Info); err != nil {
+				return err
+			}
+		}
+		syncDir(target)
+	}
+	syncDir(filepath.Dir(dst))
+	return nil
+}
+
+func makeCopyDir(dir string, info os.FileInfo, opts CopyOptions) error {
+	perm := os.FileMode(0755)
+	if !opts.SkipMetadata {
+		perm = info.Mode().Perm() | 0700
+	}
+	if err := os.Mkdir(dir, perm); err != nil && !IsDir(dir) {
+		return WrapErrorf(err, "could not create %v", dir)
+	}
+	if !opts.SkipMetadata {
+		if err := os.Chmod(dir, perm); err != nil {
+			return WrapErrorf(err, "could not set permissions on %v", dir)
+		}
+	}
+	return nil
+}
+
+// Move a file, directory or symlink to dst.  This is a rename where possible.  Across filesystems, where a rename
+// fails, src is copied to dst with CopyFile or CopyTree, flushed to disk, and only then deleted.  opts is only used
+// for the copy.
+//
+// If a copy fails, src is left alone, and so is dst if it already existed.
+func MoveFile(src, dst string, opts CopyOptions) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if !isCrossDevice(err) {
+		return WrapErrorf(err, "could not move %v to %v", src, dst)
+	}
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		return WrapErrorf(err, "could not read %v", src)
+	}
+	existed := Exists(dst)
+	if info.IsDir() {
+		err = CopyTree(src, dst, opts)
+	} else {
+		err = CopyFile(src, dst, opts)
+	}
+	if err != nil {
+		if !existed {
+			os.RemoveAll(dst)
+		}
+		return err
+	}
+	if err := os.RemoveAll(src); err != nil {
+		return WrapErrorf(err, "could not delete %v after copying it to %v", src, dst)
+	}
+	syncDir(filepath.Dir(src))
+	return nil
+}
+
+// Did a rename fail because the paths are on different filesystems?  Windows calls this ERROR_NOT_SAME_DEVICE.
+func isCrossDevice(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == syscall.EXDEV || runtime.GOOS == "windows" && errno == 17
+}
+
+// Make a symlink to the same target as src beside dst, and rename it into place
+func copySymlink(src, dst string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return WrapErrorf(err, "could not read symlink %v", src)
+	}
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".link-tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return WrapErrorf(err, "could not create symlink %v", dst)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return WrapErrorf(err, "could not replace %v", dst)
+	}
+	return nil
+}
+
+// Give dst the permissions and modification time in info
+func copyMetadata(dst string, info os.FileInfo) error {
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return WrapErrorf(err, "could not set permissions on %v", dst)
+	}
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return WrapErrorf(err, "could not set the time on %v", dst)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package goof
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Make dst share src's data, on filesystems that support reflinks.  Returns false if that isn't possible, and the data
+// has to be copied.
+func cloneFile(dst, src *os.File) bool {
+	conn, err := src.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var cloneErr error
+	err = conn.Control(func(fd uintptr) {
+		cloneErr = ioctl(dst, unix.FICLONE, fd)
+	})
+	return err == nil && cloneErr == nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package goof
+
+import "os"
+
+// Reflinks aren't supported here, so the data is always copied
+func cloneFile(dst, src *os.File) bool {
+	return false
+}
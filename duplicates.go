@@ -0,0 +1,236 @@
+package goof
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// What FindDuplicatesWith does with the duplicates it finds
+type DuplicateAction int
+
+const (
+	DuplicatesReport           DuplicateAction = iota // Just list them
+	DuplicatesHardlink                                // Replace each duplicate with a hard link to the oldest copy
+	DuplicatesDeleteKeepOldest                        // Delete every copy except the oldest
+)
+
+// Only this much of the start and end of each file is read, to weed out files that are obviously different
+const partialHashSize = 4096
+
+// Settings for FindDuplicatesWith.  The zero value reports duplicates without changing anything.
+type DuplicateOptions struct {
+	Action  DuplicateAction
+	DryRun  bool        // Work out what Action would do, but don't do it
+	MinSize int64       // Ignore files smaller than this.  Empty files are always ignored
+	Workers int         // How many files to hash at once.  Default is the number of CPUs
+	Walk    WalkOptions // Which files to look at.  Only regular files are considered, whatever Types says
+}
+
+// Files with the same contents
+type DuplicateGroup struct {
+	Size   int64
+	Digest string   // sha256 of the contents
+	Files  []string // Oldest first.  The first one is the one that is kept
+	Acted  []string // The files that were (or in a dry run, would be) replaced with links or deleted
+}
+
+// Find files with the same contents in one or more directories.  See FindDuplicatesWith.
+func FindDuplicates(roots ...string) ([]DuplicateGroup, []error) {
+	return FindDuplicatesWith(DuplicateOptions{}, roots...)
+}
+
+// Find files with the same contents in one or more directories, and optionally get rid of the extra copies.
+//
+// Files are compared by size first, then by a hash of their first and last few kilobytes, and only then by a hash of
+// the whole file, so most files are read only a little, or not at all.  Hard links to the same file count as one file.
+//
+// Returns the groups of duplicates, sorted by their first file, and any errors.  Files that can't be read are left out.
+func FindDuplicatesWith(opts DuplicateOptions, roots ...string) ([]DuplicateGroup, []error) {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.MinSize < 1 {
+		opts.MinSize = 1
+	}
+	walkOpts := opts.Walk
+	walkOpts.Types = WalkFiles
+
+	errs := []error{}
+	bySize := map[int64][]WalkEntry{}
+	for _, root := range roots {
+		entries, walkErrs := Walk(root, walkOpts)
+		errs = append(errs, walkErrs...)
+		for _, e := range entries {
+			if size := e.Info.Size(); size >= opts.MinSize {
+				bySize[size] = addUniqueFile(bySize[size], e)
+			}
+		}
+	}
+
+	candidates := [][]WalkEntry{}
+	for _, group := range bySize {
+		if len(group) > 1 {
+			candidates = append(candidates, group)
+		}
+	}
+	candidates, _, hashErrs := splitByHash(candidates, opts.Workers, partialHash)
+	errs = append(errs, hashErrs...)
+	candidates, digests, hashErrs := splitByHash(candidates, opts.Workers, func(e WalkEntry) (string, error) {
+		sums, err := HashFile(e.Path, "sha256")
+		return sums["sha256"], err
+	})
+	errs = append(errs, hashErrs...)
+
+	groups := []DuplicateGroup{}
+	for _, c := range candidates {
+		sort.Slice(c, func(i, j int) bool {
+			if !c[i].Info.ModTime().Equal(c[j].Info.ModTime()) {
+				return c[i].Info.ModTime().Before(c[j].Info.ModTime())
+			}
+			return c[i].Path < c[j].Path
+		})
+		g := DuplicateGroup{Size: c[0].Info.Size(), Acted: []string{}}
+		for _, e := range c {
+			g.Files = append(g.Files, e.Path)
+		}
+		g.Digest = digests[c[0].Path]
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Files[0] < groups[j].Files[0] })
+
+	for i := range groups {
+		errs = append(errs, actOnDuplicates(&groups[i], opts)...)
+	}
+	return groups, errs
+}
+
+// Add e to files, unless it is a hard link to one of them, or the same path reached through another root
+func addUniqueFile(files []WalkEntry, e WalkEntry) []WalkEntry {
+	for _, f := range files {
+		if os.SameFile(f.Info, e.Info) {
+			return files
+		}
+	}
+	return append(files, e)
+}
+
+// Hash the files in each group, and split the groups by hash.  Groups with only one file are dropped.  Also returns
+// the hash of each file.
+func splitByHash(groups [][]WalkEntry, workers int, hash func(WalkEntry) (string, error)) ([][]WalkEntry, map[string]string, []error) {
+	var mu sync.Mutex
+	digests := map[string]string{}
+	errs := []error{}
+	jobs := make(chan WalkEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				digest, err := hash(e)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					digests[e.Path] = digest
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, g := range groups {
+		for _, e := range g {
+			jobs <- e
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	out := [][]WalkEntry{}
+	for _, g := range groups {
+		split := map[string][]WalkEntry{}
+		for _, e := range g {
+			if d, ok := digests[e.Path]; ok {
+				split[d] = append(split[d], e)
+			}
+		}
+		for _, s := range split {
+			if len(s) > 1 {
+				out = append(out, s)
+			}
+		}
+	}
+	return out, digests, errs
+}
+
+// Hash the first and last few kilobytes of a file
+func partialHash(e WalkEntry) (string, error) {
+	f, err := os.Open(e.Path)
+	if err != nil {
+		return "", WrapErrorf(err, "could not open %v", e.Path)
+	}
+	defer f.Close()
+	h := xxhash.New()
+	if _, err := io.CopyN(h, f, partialHashSize); err != nil && err != io.EOF {
+		return "", WrapErrorf(err, "could not read %v", e.Path)
+	}
+	if size := e.Info.Size(); size > 2*partialHashSize {
+		if _, err := f.Seek(size-partialHashSize, io.SeekStart); err != nil {
+			return "", WrapErrorf(err, "could not seek in %v", e.Path)
+		}
+		if _, err := io.CopyN(h, f, partialHashSize); err != nil && err != io.EOF {
+			return "", WrapErrorf(err, "could not read %v", e.Path)
+		}
+	}
+	return string(h.Sum(nil)), nil
+}
+
+// Link or delete the extra copies in a group, as opts says
+func actOnDuplicates(g *DuplicateGroup, opts DuplicateOptions) []error {
+	if opts.Action == DuplicatesReport {
+		return nil
+	}
+	errs := []error{}
+	keep := g.Files[0]
+	for _, dup := range g.Files[1:] {
+		if !opts.DryRun {
+			var err error
+			switch opts.Action {
+			case DuplicatesHardlink:
+				err = replaceWithLink(keep, dup)
+			case DuplicatesDeleteKeepOldest:
+				if err = os.Remove(dup); err != nil {
+					err = WrapErrorf(err, "could not delete %v", dup)
+				}
+			default:
+				err = NewErrorf("unknown duplicate action %v", opts.Action)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		g.Acted = append(g.Acted, dup)
+	}
+	return errs
+}
+
+// Replace dup with a hard link to keep.  The link is made beside dup and renamed over it, so dup is never missing.
+func replaceWithLink(keep, dup string) error {
+	tmp := filepath.Join(filepath.Dir(dup), "."+filepath.Base(dup)+".link-tmp")
+	os.Remove(tmp)
+	if err := os.Link(keep, tmp); err != nil {
+		return WrapErrorf(err, "could not link %v to %v", dup, keep)
+	}
+	if err := os.Rename(tmp, dup); err != nil {
+		os.Remove(tmp)
+		return WrapErrorf(err, "could not replace %v with a link to %v", dup, keep)
+	}
+	return nil
+}
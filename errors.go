@@ -145,3 +145,8 @@ func WrapErrorf(err error, format string, args ...interface{}) error {
     return WrapError(err, context)
 }
 
+
+// Unwrap returns the original error, so errors.Is and errors.As can see through the context
+func (e *ErrorWithContext) Unwrap() error {
+    return e.Err
+}
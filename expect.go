@@ -0,0 +1,182 @@
+package goof
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned (wrapped in an ErrorWithContext) when Expect or ExpectAny runs out of time
+var ErrExpectTimeout = errors.New("timed out waiting for output")
+
+// Returned (wrapped in an ErrorWithContext) when the program closes its output before a pattern matches
+var ErrExpectEOF = errors.New("output closed while waiting for pattern")
+
+// Drives an interactive program, in the style of expect(1).  Works with any of the channel wrappers: WrapCmd, WrapProc,
+// WrapHandle, WrapCmdContext and WrapPTY.
+//
+// Output that has been read but not matched yet is kept, and searched by the next Expect.  Everything sent and received
+// is recorded in the transcript.  If Log is set, the transcript is also copied to it as it happens.
+type Expecter struct {
+	Log io.Writer
+
+	stdin      chan []byte
+	incoming   chan []byte
+	outputDone chan struct{}
+	closed     bool
+	buf        []byte
+	transcript bytes.Buffer
+}
+
+// One branch of ExpectAny.  If Pattern matches, Action is called with the match and submatches, and its error is
+// returned from ExpectAny.  Action can be nil.
+type ExpectCase struct {
+	Pattern *regexp.Regexp
+	Action  func(matches []string) error
+}
+
+// Create an Expecter that sends to stdin, and reads from all the outputs (e.g. stdout and stderr)
+func NewExpecter(stdin chan []byte, outputs ...chan []byte) *Expecter {
+	e := &Expecter{
+		stdin:      stdin,
+		incoming:   make(chan []byte),
+		outputDone: make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	for _, out := range outputs {
+		wg.Add(1)
+		go func(out chan []byte) {
+			defer wg.Done()
+			for data := range out {
+				e.incoming <- data
+			}
+		}(out)
+	}
+	go func() {
+		wg.Wait()
+		close(e.outputDone)
+		close(e.incoming)
+	}()
+	return e
+}
+
+// Create an Expecter for a program started by WrapCmdContext or WrapPTY
+func ExpectProcess(p *Process) *Expecter {
+	return NewExpecter(p.Stdin, p.Stdout, p.Stderr)
+}
+
+// Send text to the program.  Fails if the program has closed its output, because it has probably exited.
+func (e *Expecter) Send(s string) error {
+	select {
+	case <-e.outputDone:
+		return e.sendFailed(s)
+	default:
+	}
+	select {
+	case e.stdin <- []byte(s):
+		e.record([]byte(s))
+		return nil
+	case <-e.outputDone:
+		return e.sendFailed(s)
+	}
+}
+
+func (e *Expecter) sendFailed(s string) error {
+	return NewError(ErrExpectEOF, fmt.Sprintf("program has closed its output, could not send %q", s))
+}
+
+// Send text to the program, followed by a newline
+func (e *Expecter) SendLine(s string) error {
+	return e.Send(s + "\n")
+}
+
+// Wait until the program's output matches re, and return the match and submatches.  The output up to the end of the
+// match is consumed.
+func (e *Expecter) Expect(re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	var matches []string
+	_, err := e.ExpectAny(timeout, ExpectCase{Pattern: re, Action: func(m []string) error {
+		matches = m
+		return nil
+	}})
+	return matches, err
+}
+
+// Wait until any of the patterns match, run the matching case's Action, and return the index of the case.  If more
+// than one pattern matches, the one that matches earliest in the output wins, then the first in the list.
+func (e *Expecter) ExpectAny(timeout time.Duration, cases ...ExpectCase) (int, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		if i, matches := e.match(cases); i >= 0 {
+			if cases[i].Action != nil {
+				return i, cases[i].Action(matches)
+			}
+			return i, nil
+		}
+		if e.closed {
+			return -1, NewError(ErrExpectEOF, e.describe(cases))
+		}
+		select {
+		case data, ok := <-e.incoming:
+			if !ok {
+				e.closed = true
+				continue
+			}
+			e.record(data)
+			e.buf = append(e.buf, data...)
+		case <-deadline.C:
+			return -1, NewError(ErrExpectTimeout, fmt.Sprintf("after %v, %v", timeout, e.describe(cases)))
+		}
+	}
+}
+
+// Find the earliest match in the buffer, and consume the buffer up to the end of it
+func (e *Expecter) match(cases []ExpectCase) (int, []string) {
+	best, bestLoc := -1, []int(nil)
+	for i, c := range cases {
+		loc := c.Pattern.FindSubmatchIndex(e.buf)
+		if loc != nil && (bestLoc == nil || loc[0] < bestLoc[0]) {
+			best, bestLoc = i, loc
+		}
+	}
+	if best < 0 {
+		return -1, nil
+	}
+	matches := make([]string, len(bestLoc)/2)
+	for i := range matches {
+		if bestLoc[2*i] >= 0 {
+			matches[i] = string(e.buf[bestLoc[2*i]:bestLoc[2*i+1]])
+		}
+	}
+	e.buf = e.buf[bestLoc[1]:]
+	return best, matches
+}
+
+func (e *Expecter) describe(cases []ExpectCase) string {
+	patterns := []string{}
+	for _, c := range cases {
+		patterns = append(patterns, c.Pattern.String())
+	}
+	tail := e.buf
+	if len(tail) > 200 {
+		tail = tail[len(tail)-200:]
+	}
+	return fmt.Sprintf("waiting for %v, unmatched output ends with: %q", strings.Join(patterns, " or "), tail)
+}
+
+func (e *Expecter) record(data []byte) {
+	e.transcript.Write(data)
+	if e.Log != nil {
+		e.Log.Write(data)
+	}
+}
+
+// Everything sent to and received from the program so far
+func (e *Expecter) Transcript() string {
+	return e.transcript.String()
+}
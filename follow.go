@@ -0,0 +1,211 @@
+package goof
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Settings for Follow.  The zero value starts at the end of the file, and checks for new data 4 times a second.
+type FollowOptions struct {
+	FromStart    bool          // Read the whole file first, instead of starting at the end
+	Offset       int64         // Start at this byte offset, if it isn't 0
+	PollInterval time.Duration // How often to check for new data.  Default 250ms
+}
+
+// Follows a growing file, like tail -F.  Create one with Follow.
+type Follower struct {
+	path  string
+	opts  FollowOptions
+	lines chan Line
+	err   error
+
+	f      *os.File
+	offset int64
+	number int
+	chunk  []byte
+	buf    []byte
+}
+
+// Watch a file, and deliver each new line as it is written.  This keeps going when the file is truncated, or when it is
+// rotated (renamed, and replaced with a new file), until ctx is cancelled.  If the file doesn't exist yet, Follow waits
+// for it.
+//
+// Only whole lines are delivered, except that the last part of a rotated file is delivered even if it has no newline.
+// Line numbers count from where following started, and start again at 1 after truncation or rotation.  Lines are
+// delivered without the line ending.
+func Follow(ctx context.Context, path string, opts FollowOptions) *Follower {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 250 * time.Millisecond
+	}
+	f := &Follower{
+		path:  path,
+		opts:  opts,
+		lines: make(chan Line, 100),
+		chunk: make([]byte, 64*1024),
+	}
+	go f.run(ctx)
+	return f
+}
+
+// The new lines.  Closed when the context is cancelled, or there is an error.
+func (f *Follower) Lines() <-chan Line {
+	return f.lines
+}
+
+// The error that stopped Follow, if any.  Check it once Lines is closed.
+func (f *Follower) Err() error {
+	return f.err
+}
+
+func (f *Follower) run(ctx context.Context) {
+	defer close(f.lines)
+	defer func() {
+		if f.f != nil {
+			f.f.Close()
+		}
+	}()
+
+	first := true
+	for {
+		if f.f == nil {
+			err := f.open(first)
+			if err != nil && !os.IsNotExist(err) {
+				f.err = err
+				return
+			}
+			//A file that turns up later is read from the start
+			first = false
+		}
+		//Check for truncation and rotation after every chunk, so a file that grows quickly doesn't hide them
+		for f.f != nil {
+			more, err := f.readChunk(ctx)
+			if err != nil {
+				f.err = err
+				return
+			}
+			if err := f.checkFile(ctx); err != nil {
+				f.err = err
+				return
+			}
+			if !more {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.opts.PollInterval):
+		}
+	}
+}
+
+// Open the file.  The first time, start where the options say, after that start at the beginning.
+func (f *Follower) open(first bool) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return err
+		}
+		return WrapErrorf(err, "could not open %v", f.path)
+	}
+	offset := int64(0)
+	switch {
+	case !first:
+	case f.opts.Offset != 0:
+		offset, err = file.Seek(f.opts.Offset, io.SeekStart)
+	case !f.opts.FromStart:
+		offset, err = file.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		file.Close()
+		return WrapErrorf(err, "could not seek in %v", f.path)
+	}
+	f.f, f.offset, f.number, f.buf = file, offset, 0, nil
+	return nil
+}
+
+// Read until the end of the file, and send any complete lines
+func (f *Follower) readAvailable(ctx context.Context) error {
+	for {
+		more, err := f.readChunk(ctx)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// Read one chunk, and send any complete lines.  Returns false at the end of the file, or if ctx is cancelled.
+func (f *Follower) readChunk(ctx context.Context) (bool, error) {
+	n, err := f.f.Read(f.chunk)
+	if n > 0 {
+		f.offset += int64(n)
+		f.buf = append(f.buf, f.chunk[:n]...)
+		if !f.sendLines(ctx) {
+			return false, nil
+		}
+	}
+	if err == io.EOF || n == 0 {
+		return false, nil
+	}
+	if err != nil {
+		return false, WrapErrorf(err, "could not read %v", f.path)
+	}
+	return true, nil
+}
+
+// Send the complete lines in buf.  Returns false if ctx was cancelled.
+func (f *Follower) sendLines(ctx context.Context) bool {
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			return true
+		}
+		if !f.send(ctx, string(trimLineEnding(f.buf[:i+1]))) {
+			return false
+		}
+		f.buf = f.buf[i+1:]
+	}
+}
+
+func (f *Follower) send(ctx context.Context, text string) bool {
+	f.number++
+	select {
+	case f.lines <- Line{Source: f.path, Number: f.number, Text: text}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Look for truncation and rotation
+func (f *Follower) checkFile(ctx context.Context) error {
+	info, err := f.f.Stat()
+	if err != nil {
+		return WrapErrorf(err, "could not check %v", f.path)
+	}
+	if info.Size() < f.offset {
+		if _, err := f.f.Seek(0, io.SeekStart); err != nil {
+			return WrapErrorf(err, "could not seek in %v", f.path)
+		}
+		f.offset, f.number, f.buf = 0, 0, nil
+		return nil
+	}
+
+	//If there's no new file yet, keep reading the old one, in case it is still being written
+	current, err := os.Stat(f.path)
+	if err != nil || os.SameFile(info, current) {
+		return nil
+	}
+	if err := f.readAvailable(ctx); err != nil {
+		return err
+	}
+	if len(f.buf) > 0 {
+		f.send(ctx, string(f.buf))
+	}
+	f.f.Close()
+	f.f = nil
+	return nil
+}
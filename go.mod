@@ -2,4 +2,11 @@ module github.com/donomii/goof
 
 go 1.16
 
-
+require (
+	github.com/cespare/xxhash/v2 v2.2.0
+	github.com/klauspost/compress v1.13.6
+	github.com/pierrec/lz4/v4 v4.1.17
+	github.com/ulikunitz/xz v0.5.11
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
+)
@@ -0,0 +1,234 @@
+package goof
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+// The hash algorithms HashFile and friends understand.  blake2b is BLAKE2b-512, xxhash is XXH64, and crc32 uses the
+// IEEE polynomial, like zip and gzip.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+	"xxhash": func() hash.Hash { return xxhash.New() },
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+}
+
+// Hash everything in r, with one or more algorithms at once.  Returns the digests in hex, keyed by algorithm.  If no
+// algorithms are given, uses sha256.
+//
+// Algorithms are "md5", "sha1", "sha256", "sha512", "blake2b", "xxhash" and "crc32".
+func HashReader(r io.Reader, algos ...string) (map[string]string, error) {
+	if len(algos) == 0 {
+		algos = []string{"sha256"}
+	}
+	hashes := map[string]hash.Hash{}
+	writers := []io.Writer{}
+	for _, a := range algos {
+		newHash, ok := hashAlgorithms[a]
+		if !ok {
+			return nil, NewErrorf("unknown hash algorithm %q", a)
+		}
+		h := newHash()
+		hashes[a] = h
+		writers = append(writers, h)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, WrapError(err, "could not read data to hash")
+	}
+	out := map[string]string{}
+	for a, h := range hashes {
+		out[a] = hex.EncodeToString(h.Sum(nil))
+	}
+	return out, nil
+}
+
+// Hash a file with one or more algorithms, reading it only once.  See HashReader.
+func HashFile(path string, algos ...string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, WrapErrorf(err, "could not open %v", path)
+	}
+	defer f.Close()
+	sums, err := HashReader(bufio.NewReaderSize(f, 256*1024), algos...)
+	if err != nil {
+		return nil, WrapErrorf(err, "could not hash %v", path)
+	}
+	return sums, nil
+}
+
+// Digests for the files in a directory, keyed by their path relative to the directory, with / as the separator
+type Manifest map[string]string
+
+// Settings for HashTree and VerifyTree.  The zero value uses sha256, and hashes one file per CPU at once.
+type HashTreeOptions struct {
+	Algorithm string
+	Workers   int
+}
+
+// Hash every regular file in dir and its subdirectories.  Symlinks are not followed.  If some files or directories
+// can't be read, returns the first error, along with the digests of everything that could be read.
+func HashTree(dir string, opts HashTreeOptions) (Manifest, error) {
+	if opts.Algorithm == "" {
+		opts.Algorithm = "sha256"
+	}
+	if _, ok := hashAlgorithms[opts.Algorithm]; !ok {
+		return nil, NewErrorf("unknown hash algorithm %q", opts.Algorithm)
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	files, firstErr := regularFiles(dir)
+
+	var mu sync.Mutex
+	m := Manifest{}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range jobs {
+				sums, err := HashFile(filepath.Join(dir, filepath.FromSlash(rel)), opts.Algorithm)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil {
+					m[rel] = sums[opts.Algorithm]
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, rel := range files {
+		jobs <- rel
+	}
+	close(jobs)
+	wg.Wait()
+	return m, firstErr
+}
+
+// The regular files under dir, relative to dir, with / as the separator.  If some directories can't be read, returns
+// the first error, along with the files that were found.
+func regularFiles(dir string) ([]string, error) {
+	entries, errs := Walk(dir, WalkOptions{Types: WalkFiles})
+	files := []string{}
+	for _, e := range entries {
+		files = append(files, e.Rel)
+	}
+	if len(errs) > 0 {
+		return files, errs[0]
+	}
+	return files, nil
+}
+
+// Write the manifest in the same format as sha256sum and friends, sorted by path
+func (m Manifest) Write(w io.Writer) error {
+	paths := []string{}
+	for p := range m {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	bw := bufio.NewWriter(w)
+	for _, p := range paths {
+		if _, err := bw.WriteString(m[p] + "  " + p + "\n"); err != nil {
+			return WrapError(err, "could not write manifest")
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return WrapError(err, "could not write manifest")
+	}
+	return nil
+}
+
+// Read a manifest in the format written by Manifest.Write, or by sha256sum and friends
+func ReadManifest(r io.Reader) (Manifest, error) {
+	m := Manifest{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 || len(line) <= i+2 {
+			return nil, NewErrorf("could not parse manifest line %v: %q", n, line)
+		}
+		//The character after the space is ' ' for text mode and '*' for binary mode
+		m[line[i+2:]] = line[:i]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, WrapError(err, "could not read manifest")
+	}
+	return m, nil
+}
+
+// The differences between a manifest and a directory
+type ManifestDiff struct {
+	Missing []string // In the manifest, but not the directory
+	Extra   []string // In the directory, but not the manifest
+	Changed []string // In both, with different digests
+}
+
+// True if the directory matched the manifest exactly
+func (d *ManifestDiff) OK() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Changed) == 0
+}
+
+// Hash the files in dir, and compare them with a manifest made by HashTree, using the same options.  If some files
+// can't be read, they are listed as missing, and the first error is returned along with the differences.
+func VerifyTree(dir string, m Manifest, opts HashTreeOptions) (*ManifestDiff, error) {
+	current, err := HashTree(dir, opts)
+	if current == nil {
+		return nil, err
+	}
+	return m.Compare(current), err
+}
+
+// Compare this manifest with another one, taking this as the expected one.  The lists are sorted.
+func (m Manifest) Compare(current Manifest) *ManifestDiff {
+	d := &ManifestDiff{Missing: []string{}, Extra: []string{}, Changed: []string{}}
+	for p, digest := range m {
+		got, ok := current[p]
+		switch {
+		case !ok:
+			d.Missing = append(d.Missing, p)
+		case !strings.EqualFold(got, digest):
+			d.Changed = append(d.Changed, p)
+		}
+	}
+	for p := range current {
+		if _, ok := m[p]; !ok {
+			d.Extra = append(d.Extra, p)
+		}
+	}
+	sort.Strings(d.Missing)
+	sort.Strings(d.Extra)
+	sort.Strings(d.Changed)
+	return d
+}
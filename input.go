@@ -0,0 +1,326 @@
+package goof
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// The first bytes of each compressed format we can read
+var compressionMagic = []struct {
+	name  string
+	magic []byte
+}{
+	{"gz", []byte{0x1f, 0x8b}},
+	{"bz2", []byte("BZh")},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"zst", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"lz4", []byte{0x04, 0x22, 0x4d, 0x18}},
+	{"lz4", []byte{0x02, 0x21, 0x4c, 0x18}}, //Legacy frame format
+}
+
+// The longest magic number in compressionMagic
+const maxMagicLen = 6
+
+// Filename suffixes for each compressed format, for data we don't recognise
+var compressionSuffixes = map[string]string{
+	".gz": "gz", ".tgz": "gz", ".gzip": "gz",
+	".zlib": "zlib", ".zz": "zlib",
+	".bz2": "bz2", ".tbz2": "bz2",
+	".xz": "xz", ".txz": "xz",
+	".zst": "zst", ".zstd": "zst",
+	".lz4": "lz4",
+}
+
+// The compression settings OpenInputE understands
+var compressionModes = map[string]bool{
+	"": true, "none": true, "gz": true, "zlib": true, "bz2": true, "xz": true, "zst": true, "lz4": true,
+	"pgz": true, "pzst": true, "parallel": true,
+}
+
+// Opens a file or stdin, and decompresses it if it is compressed.  Pass "" or "-" as a filename to read stdin.
+//
+// Compression is one of "gz", "zlib", "bz2", "xz", "zst", "lz4" or "none".  If compression is "", the format is worked
+// out from the first few bytes of the data, or from the filename if that doesn't work.
+//
+// "pgz" and "pzst" decompress gzip and zstd files using all CPUs, if the file is made of many gzip members (e.g. from
+// bgzip or pigz --independent) or zstd frames (e.g. from zstd -T0).  Other files are decompressed normally.
+// "parallel" works out the format, and uses parallel decompression if it can.
+//
+// The filename can also be:
+//
+//	an http:// or https:// URL, or a file:///absolute/path URL
+//	a member of a tar or zip archive, e.g. "logs.tar.gz#logs/today.log", or "http://host/logs.zip#today.log"
+//
+// Archives are decompressed automatically, and compression applies to the member.  Remote files are streamed, except
+// zip files, which have to be downloaded completely first.
+//
+// Close closes the decompressor and the file (but not stdin).
+func OpenInputE(filename string, compression string) (io.ReadCloser, error) {
+	src, name, member, err := openSource(filename)
+	if err != nil {
+		return nil, err
+	}
+	closers := []func() error{src.Close}
+	var raw io.Reader = src
+	if member != "" {
+		m, err := openMember(src, name, member)
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		closers = append([]func() error{m.Close}, closers...)
+		raw, name = m, member
+	}
+
+	r, err := decompress(raw, name, compression)
+	if err != nil {
+		closeAllInputs(closers)
+		return nil, err
+	}
+	return &inputCloser{Reader: r, closers: append([]func() error{closeReader(r)}, closers...)}, nil
+}
+
+// Open a file, stdin or URL, without decompressing it.  Also returns the name to use for guessing the compression,
+// and the archive member, if one was asked for.
+func openSource(filename string) (io.ReadCloser, string, string, error) {
+	if filename == "" || filename == "-" {
+		return ioutil.NopCloser(os.Stdin), filename, "", nil
+	}
+
+	lower := strings.ToLower(filename)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+		u, err := url.Parse(filename)
+		if err != nil {
+			return nil, "", "", WrapErrorf(err, "could not parse url %v", filename)
+		}
+		member := u.Fragment
+		u.Fragment = ""
+		body, err := httpBody(u.String())
+		return body, u.Path, member, err
+	}
+
+	member := ""
+	if strings.HasPrefix(lower, "file://") {
+		u, err := url.Parse(filename)
+		if err != nil {
+			return nil, "", "", WrapErrorf(err, "could not parse url %v", filename)
+		}
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, "", "", NewErrorf("could not open %v, file urls can't name another host (use file:///path)", filename)
+		}
+		filename, member = u.Path, u.Fragment
+	} else if i := strings.LastIndex(filename, "#"); i > 0 && !Exists(filename) && Exists(filename[:i]) {
+		filename, member = filename[:i], filename[i+1:]
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, "", "", WrapErrorf(err, "could not open %v", filename)
+	}
+	return f, filename, member, nil
+}
+
+// Start a GET request, and return the body as it arrives
+func httpBody(u string) (io.ReadCloser, error) {
+	client := http.Client{Transport: httpTransport}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, WrapErrorf(err, "could not fetch %v", u)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, NewErrorf("could not fetch %v: server responded with %v", u, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Find member in the tar or zip archive in src, and return a reader for it
+func openMember(src io.Reader, name, member string) (io.ReadCloser, error) {
+	member = cleanMemberName(member)
+
+	//Zip files keep their index at the end, so they need random access
+	if f, ok := src.(*os.File); ok {
+		magic := make([]byte, 4)
+		if _, err := f.ReadAt(magic, 0); err == nil && bytes.Equal(magic, zipMagic) {
+			info, err := f.Stat()
+			if err != nil {
+				return nil, WrapErrorf(err, "could not read %v", name)
+			}
+			return openZipMember(f, info.Size(), name, member)
+		}
+	}
+
+	archive, err := decompress(src, name, "")
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(archive)
+	if magic, _ := buffered.Peek(4); bytes.Equal(magic, zipMagic) {
+		data, err := ioutil.ReadAll(buffered)
+		if err != nil {
+			return nil, WrapErrorf(err, "could not read %v", name)
+		}
+		return openZipMember(bytes.NewReader(data), int64(len(data)), name, member)
+	}
+
+	tr := tar.NewReader(buffered)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, NewErrorf("%v is not in %v", member, name)
+		}
+		if err != nil {
+			return nil, WrapErrorf(err, "could not read archive %v", name)
+		}
+		if cleanMemberName(hdr.Name) == member && hdr.Typeflag != tar.TypeDir {
+			return &inputCloser{Reader: tr, closers: []func() error{closeReader(archive)}}, nil
+		}
+	}
+}
+
+var zipMagic = []byte("PK\x03\x04")
+
+func openZipMember(r io.ReaderAt, size int64, name, member string) (io.ReadCloser, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, WrapErrorf(err, "could not read zip file %v", name)
+	}
+	for _, f := range zr.File {
+		if cleanMemberName(f.Name) == member && !f.FileInfo().IsDir() {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, WrapErrorf(err, "could not open %v in %v", member, name)
+			}
+			return rc, nil
+		}
+	}
+	return nil, NewErrorf("%v is not in %v", member, name)
+}
+
+// Archive members can be stored as "./a/b" or "a/b"
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func closeAllInputs(closers []func() error) error {
+	var first error
+	for _, close := range closers {
+		if err := close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Wrap src in a decompressor, chosen as described in OpenInputE
+func decompress(src io.Reader, filename, compression string) (io.Reader, error) {
+	buffered := bufio.NewReaderSize(src, 64*1024)
+	if compression == "" {
+		compression = sniffCompression(buffered, filename)
+	}
+	if compression == "parallel" {
+		switch c := sniffCompression(buffered, filename); c {
+		case "gz", "zst":
+			compression = "p" + c
+		default:
+			compression = c
+		}
+	}
+
+	var r io.Reader
+	var err error
+	switch compression {
+	case "none":
+		return buffered, nil
+	case "gz":
+		r, err = gzip.NewReader(buffered)
+	case "zlib":
+		r, err = zlib.NewReader(buffered)
+	case "bz2":
+		r = bzip2.NewReader(buffered)
+	case "xz":
+		r, err = xz.NewReader(buffered)
+	case "zst":
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err == nil {
+			r = zstdReadCloser{d}
+		}
+	case "lz4":
+		r = lz4.NewReader(buffered)
+	case "pgz":
+		r = newParallelGzipReader(buffered)
+	case "pzst":
+		r, err = newParallelZstdReader(buffered)
+	default:
+		return nil, NewErrorf("unknown compression %q for %v", compression, filename)
+	}
+	if err != nil {
+		return nil, WrapErrorf(err, "could not start %v decompression for %v", compression, filename)
+	}
+	return r, nil
+}
+
+// Work out the compression from the magic number at the start of the data, or failing that, the filename.  Only the
+// first read is waited for, so a pipe that sends a short line and then pauses isn't held up waiting for more.
+func sniffCompression(r *bufio.Reader, filename string) string {
+	r.Peek(1)
+	n := r.Buffered()
+	if n > maxMagicLen {
+		n = maxMagicLen
+	}
+	head, _ := r.Peek(n)
+	for _, m := range compressionMagic {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.name
+		}
+	}
+	if c, ok := compressionSuffixes[strings.ToLower(filepath.Ext(filename))]; ok {
+		return c
+	}
+	return "none"
+}
+
+// zstd.Decoder's Close doesn't return an error
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// Returns r's Close method, or a function that does nothing if it doesn't have one
+func closeReader(r io.Reader) func() error {
+	if c, ok := r.(io.Closer); ok {
+		return c.Close
+	}
+	return func() error { return nil }
+}
+
+// Closes a stack of readers, from the top down
+type inputCloser struct {
+	io.Reader
+	closers []func() error
+}
+
+func (c *inputCloser) Close() error {
+	return closeAllInputs(c.closers)
+}
@@ -0,0 +1,171 @@
+package goof
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const inputText = "first line\nsecond line\n"
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// A .tar.gz and a .zip, each holding logs/today.log
+func testArchives(t *testing.T) map[string][]byte {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	tw.WriteHeader(&tar.Header{Name: "logs/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "./logs/today.log", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(inputText))})
+	tw.Write([]byte(inputText))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, err := zw.Create("logs/today.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(inputText))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string][]byte{
+		"/archive.tar.gz": gzipBytes(t, tarBuf.Bytes()),
+		"/archive.zip":    zipBuf.Bytes(),
+	}
+}
+
+// Serve the test archives, plain and gzipped text, and some errors
+func testServer(t *testing.T) *httptest.Server {
+	files := testArchives(t)
+	files["/plain.txt"] = []byte(inputText)
+	files["/text.gz"] = gzipBytes(t, []byte(inputText))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func readInput(t *testing.T, filename string) (string, error) {
+	r, err := OpenInputE(filename, "")
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	return string(data), err
+}
+
+func TestOpenInputURL(t *testing.T) {
+	server := testServer(t)
+	for _, path := range []string{
+		"/plain.txt",
+		"/text.gz",
+		"/archive.tar.gz#logs/today.log",
+		"/archive.zip#logs/today.log",
+		"/archive.zip#./logs/today.log",
+	} {
+		data, err := readInput(t, server.URL+path)
+		if err != nil {
+			t.Errorf("%v: %v", path, err)
+		} else if data != inputText {
+			t.Errorf("%v: read %q", path, data)
+		}
+	}
+}
+
+func TestOpenInputURLErrors(t *testing.T) {
+	server := testServer(t)
+	for _, path := range []string{
+		"/missing",
+		"/broken",
+		"/archive.tar.gz#logs/yesterday.log",
+		"/archive.zip#logs/yesterday.log",
+		"/archive.tar.gz#logs",
+	} {
+		if _, err := readInput(t, server.URL+path); err == nil {
+			t.Errorf("%v: no error", path)
+		}
+	}
+}
+
+func TestOpenInputArchiveFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range testArchives(t) {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, filename := range []string{path + "#logs/today.log", "file://" + filepath.ToSlash(path) + "#logs/today.log"} {
+			data, err := readInput(t, filename)
+			if err != nil {
+				t.Errorf("%v: %v", filename, err)
+			} else if data != inputText {
+				t.Errorf("%v: read %q", filename, data)
+			}
+		}
+		if _, err := readInput(t, path+"#logs/yesterday.log"); err == nil || !strings.Contains(err.Error(), "is not in") {
+			t.Errorf("%v: missing member gave %v", name, err)
+		}
+	}
+}
+
+// file://name/path would otherwise open /path
+func TestOpenInputFileURLHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain.txt")
+	if err := ioutil.WriteFile(path, []byte(inputText), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readInput(t, "file://relative"+filepath.ToSlash(path)); err == nil {
+		t.Error("file://relative/...: no error")
+	}
+	if data, err := readInput(t, "file://localhost"+filepath.ToSlash(path)); err != nil || data != inputText {
+		t.Errorf("file://localhost: read %q, %v", data, err)
+	}
+}
+
+// OpenInput ignores compression settings it doesn't know, as it always has
+func TestOpenInputUnknownCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "text.gz")
+	if err := ioutil.WriteFile(path, gzipBytes(t, []byte(inputText)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := OpenInput(path, "gzip")
+	defer r.(io.Closer).Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != inputText {
+		t.Errorf("read %q, %v", data, err)
+	}
+	if _, err := OpenInputE(path, "gzip"); err == nil {
+		t.Error("OpenInputE accepted an unknown compression")
+	}
+}
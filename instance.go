@@ -0,0 +1,145 @@
+package goof
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Returned (wrapped in an ErrorWithContext) by SingleInstance when another copy of the program is running
+var ErrAlreadyRunning = errors.New("already running")
+
+// How long a second instance keeps trying to reach the first one, which may have only just started
+const forwardTimeout = 2 * time.Second
+
+// Settings for SingleInstanceWith.  The zero value only checks for another instance.
+type SingleInstanceOptions struct {
+	Forward bool     // If another instance is running, send it Args.  It receives them from Instance.Args
+	Args    []string // What to send.  Default is os.Args[1:]
+}
+
+// The one running copy of a program.  Create one with SingleInstance.
+type Instance struct {
+	lock     *FileLock
+	listener net.Listener
+	sockPath string
+	args     chan []string
+	done     chan struct{}
+	closed   sync.Once
+}
+
+// Make sure only one copy of appName runs at a time, for this user, by locking a file in the config directory (see
+// ConfigFilePath).  The first copy gets an Instance, and keeps the lock until it calls Close or exits.  Any other copy
+// gets an error wrapping ErrAlreadyRunning.
+func SingleInstance(appName string) (*Instance, error) {
+	return SingleInstanceWith(appName, SingleInstanceOptions{})
+}
+
+// Like SingleInstance, but with opts.Forward set, a second copy sends its command line to the first before giving up,
+// e.g. so the first can open a file the user double-clicked.  The first copy listens on a Unix socket beside the lock.
+func SingleInstanceWith(appName string, opts SingleInstanceOptions) (*Instance, error) {
+	if opts.Args == nil {
+		opts.Args = os.Args[1:]
+	}
+	lockPath := ConfigFilePath("." + appName + ".lock")
+	sockPath := ConfigFilePath("." + appName + ".sock")
+
+	lock, err := LockFile(lockPath, FileLockOptions{Timeout: -1})
+	if errors.Is(err, ErrLocked) {
+		running := fmt.Sprintf("%v is running", appName)
+		if pid := LockOwner(lockPath); pid > 0 {
+			running = fmt.Sprintf("%v is running as process %v", appName, pid)
+		}
+		if !opts.Forward {
+			return nil, NewError(ErrAlreadyRunning, running)
+		}
+		if err := forwardArgs(sockPath, opts.Args); err != nil {
+			return nil, NewError(ErrAlreadyRunning, fmt.Sprintf("%v, and could not be sent the arguments: %v", running, err))
+		}
+		return nil, NewError(ErrAlreadyRunning, running+", and was sent the arguments")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	in := &Instance{lock: lock, sockPath: sockPath, args: make(chan []string, 10), done: make(chan struct{})}
+	if !opts.Forward {
+		close(in.args)
+		return in, nil
+	}
+	//A socket left behind by a copy that crashed.  We have the lock, so nobody else is using it.
+	os.Remove(sockPath)
+	in.listener, err = net.Listen("unix", sockPath)
+	if err != nil {
+		lock.Unlock()
+		return nil, WrapErrorf(err, "could not listen on %v", sockPath)
+	}
+	go in.serve()
+	return in, nil
+}
+
+// Command lines sent by later copies of the program, if SingleInstanceWith was called with Forward.  Closed by Close.
+func (in *Instance) Args() <-chan []string {
+	return in.args
+}
+
+// Release the lock, so another copy can start
+func (in *Instance) Close() error {
+	var err error
+	in.closed.Do(func() {
+		close(in.done)
+		if in.listener != nil {
+			in.listener.Close()
+			os.Remove(in.sockPath)
+		}
+		err = in.lock.Unlock()
+	})
+	return err
+}
+
+// Receive command lines, one per connection
+func (in *Instance) serve() {
+	defer close(in.args)
+	for {
+		conn, err := in.listener.Accept()
+		if err != nil {
+			return
+		}
+		var args []string
+		conn.SetDeadline(time.Now().Add(forwardTimeout))
+		err = json.NewDecoder(conn).Decode(&args)
+		conn.Close()
+		if err != nil {
+			continue
+		}
+		select {
+		case in.args <- args:
+		case <-in.done:
+			return
+		}
+	}
+}
+
+// Send args to the first instance.  It may still be starting up, so keep trying for a little while.
+func forwardArgs(sockPath string, args []string) error {
+	start := time.Now()
+	for {
+		conn, err := net.Dial("unix", sockPath)
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(forwardTimeout))
+			err = json.NewEncoder(conn).Encode(args)
+			if closeErr := conn.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		if time.Since(start) >= forwardTimeout {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,258 @@
+package goof
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// A line of text, and where it came from
+type Line struct {
+	Source string // The input name, after glob expansion
+	Number int    // Line number in Source, starting at 1
+	Text   string // Without the line ending
+}
+
+// Settings for NewLineScanner.  The zero value reads one file per CPU at once, and delivers lines in whatever order
+// they arrive.
+type LineScannerOptions struct {
+	Workers       int    // How many inputs to read at once.  Default is the number of CPUs
+	PreserveOrder bool   // Deliver all the lines of each input in turn, in the order the inputs were given
+	Compression   string // Passed to OpenInputE.  Default "" detects it for each input
+	Buffer        int    // How many lines to queue up for each input.  Default 1024
+}
+
+// Reads lines from many inputs at once, decompressing them with OpenInputE.  Memory use is limited to a few buffers
+// per worker, no matter how large the files or how long the lines.
+//
+// Use it like bufio.Scanner:
+//
+//	s := goof.NewLineScanner([]string{"logs/*.gz"}, goof.LineScannerOptions{})
+//	for s.Scan() {
+//		l := s.Line()
+//		fmt.Println(l.Source, l.Number, l.Text)
+//	}
+//	if err := s.Err(); err != nil { ... }
+//
+// or range over Lines().  Scanning stops at the first error.
+type LineScanner struct {
+	lines   chan Line
+	stop    chan struct{}
+	stopped sync.Once
+	current Line
+
+	mu  sync.Mutex
+	err error
+}
+
+// Start reading inputs.  Each input is a filename, a glob pattern, "-" for stdin, or anything else OpenInputE accepts.
+func NewLineScanner(inputs []string, opts LineScannerOptions) *LineScanner {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 1024
+	}
+	s := &LineScanner{
+		lines: make(chan Line, opts.Buffer),
+		stop:  make(chan struct{}),
+	}
+
+	names, err := expandInputs(inputs)
+	if err != nil {
+		s.fail(err)
+		close(s.lines)
+		return s
+	}
+	if opts.PreserveOrder {
+		go s.runOrdered(names, opts)
+	} else {
+		go s.runUnordered(names, opts)
+	}
+	return s
+}
+
+// Expand glob patterns.  Names that aren't patterns are kept as they are, so OpenInputE can report errors for them.
+func expandInputs(inputs []string) ([]string, error) {
+	out := []string{}
+	for _, in := range inputs {
+		if strings.Contains(in, "://") || !strings.ContainsAny(in, "*?[") {
+			out = append(out, in)
+			continue
+		}
+		matches, err := filepath.Glob(in)
+		if err != nil {
+			return nil, WrapErrorf(err, "bad pattern %v", in)
+		}
+		if len(matches) == 0 {
+			return nil, NewErrorf("no files match %v", in)
+		}
+		out = append(out, matches...)
+	}
+	return out, nil
+}
+
+func (s *LineScanner) runUnordered(names []string, opts LineScannerOptions) {
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				s.readInput(name, opts.Compression, s.lines)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, name := range names {
+			select {
+			case jobs <- name:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(s.lines)
+}
+
+// Read up to Workers inputs at once, each into its own queue, and empty the queues one at a time, in order
+func (s *LineScanner) runOrdered(names []string, opts LineScannerOptions) {
+	queues := make(chan chan Line, opts.Workers)
+	go func() {
+		defer close(queues)
+		slots := make(chan struct{}, opts.Workers)
+		for _, name := range names {
+			select {
+			case slots <- struct{}{}:
+			case <-s.stop:
+				return
+			}
+			queue := make(chan Line, opts.Buffer)
+			queues <- queue
+			go func(name string) {
+				defer func() { <-slots }()
+				defer close(queue)
+				s.readInput(name, opts.Compression, queue)
+			}(name)
+		}
+	}()
+
+	for queue := range queues {
+		for l := range queue {
+			select {
+			case s.lines <- l:
+			case <-s.stop:
+			}
+		}
+	}
+	close(s.lines)
+}
+
+// Send every line of an input to out
+func (s *LineScanner) readInput(name, compression string, out chan Line) {
+	r, err := OpenInputE(name, compression)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	defer r.Close()
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	number := 0
+	for {
+		text, err := readLongLine(br)
+		if err == io.EOF && text == "" {
+			return
+		}
+		if err != nil && err != io.EOF {
+			s.fail(WrapErrorf(err, "could not read %v after line %v", name, number))
+			return
+		}
+		number++
+		select {
+		case out <- Line{Source: name, Number: number, Text: text}:
+		case <-s.stop:
+			return
+		}
+		if err == io.EOF {
+			return
+		}
+	}
+}
+
+// Read a line of any length, and remove the line ending
+func readLongLine(br *bufio.Reader) (string, error) {
+	var long []byte
+	for {
+		piece, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			long = append(long, piece...)
+			continue
+		}
+		if long != nil {
+			piece = append(long, piece...)
+		}
+		piece = trimLineEnding(piece)
+		return string(piece), err
+	}
+}
+
+func trimLineEnding(b []byte) []byte {
+	if len(b) > 0 && b[len(b)-1] == '\n' {
+		b = b[:len(b)-1]
+		if len(b) > 0 && b[len(b)-1] == '\r' {
+			b = b[:len(b)-1]
+		}
+	}
+	return b
+}
+
+// Record the first error, and stop everything
+func (s *LineScanner) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.Close()
+}
+
+// Move to the next line.  Returns false when all the inputs are finished, or there was an error.
+func (s *LineScanner) Scan() bool {
+	l, ok := <-s.lines
+	if !ok {
+		return false
+	}
+	s.current = l
+	return true
+}
+
+// The line found by the last call to Scan
+func (s *LineScanner) Line() Line {
+	return s.current
+}
+
+// The lines, as a channel.  Don't mix this with Scan.
+func (s *LineScanner) Lines() <-chan Line {
+	return s.lines
+}
+
+// The first error that happened, if any.  Check it once Scan returns false, or Lines is closed.
+func (s *LineScanner) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Stop reading early.  Lines already queued may still be delivered.
+func (s *LineScanner) Close() {
+	s.stopped.Do(func() {
+		close(s.stop)
+	})
+}
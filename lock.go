@@ -0,0 +1,99 @@
+package goof
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned (wrapped in an ErrorWithContext) when LockFile can't get the lock before its timeout
+var ErrLocked = errors.New("locked by another process")
+
+// How often LockFile tries again while it waits
+const lockRetryInterval = 10 * time.Millisecond
+
+// Settings for LockFile.  The zero value takes an exclusive lock, waiting as long as it takes.
+type FileLockOptions struct {
+	Shared  bool          // Allow other shared locks at the same time.  Only where flock is used; elsewhere every lock is exclusive
+	Timeout time.Duration // Give up after this long.  0 waits forever, and a negative timeout doesn't wait at all
+}
+
+// A lock on a file, shared between processes.  Create one with LockFile.
+//
+// On Linux, macOS and the BSDs this is a flock, which the system releases when the process exits, however it exits.
+// Elsewhere (e.g. Windows) the lock is the file itself, created with O_EXCL and deleted by Unlock.  If the process
+// that made it has died, the lock is stale, and the next LockFile deletes it.
+//
+// Whoever holds an exclusive lock writes their PID into the file, see LockOwner.  Locks are advisory: they only keep
+// out other processes that use LockFile on the same path.
+type FileLock struct {
+	path   string
+	shared bool
+	f      *os.File // The open lock file, where flock is used
+
+	mu   sync.Mutex
+	held bool
+}
+
+// Lock path, creating it if needed
+func LockFile(path string, opts FileLockOptions) (*FileLock, error) {
+	l := &FileLock{path: path, shared: opts.Shared}
+	start := time.Now()
+	for {
+		ok, err := l.tryLock()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			l.held = true
+			return l, nil
+		}
+		if opts.Timeout < 0 || opts.Timeout > 0 && time.Since(start) >= opts.Timeout {
+			if l.f != nil {
+				l.f.Close()
+			}
+			return nil, NewError(ErrLocked, describeLock(path))
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func describeLock(path string) string {
+	if pid := LockOwner(path); pid > 0 {
+		return fmt.Sprintf("%v is held by process %v", path, pid)
+	}
+	return fmt.Sprintf("%v is held by another process", path)
+}
+
+// Release the lock.  It is safe to call Unlock more than once.
+func (l *FileLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.held {
+		return nil
+	}
+	l.held = false
+	return l.unlock()
+}
+
+// The PID of the process holding an exclusive lock on path, or 0 if there isn't one, or it can't be told
+func LockOwner(path string) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func pidFileContents() []byte {
+	return []byte(strconv.Itoa(os.Getpid()) + "\n")
+}
@@ -0,0 +1,54 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package goof
+
+import (
+	"os"
+	"syscall"
+)
+
+// Try once to flock the file
+func (l *FileLock) tryLock() (bool, error) {
+	if l.f == nil {
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return false, WrapErrorf(err, "could not open lock file %v", l.path)
+		}
+		l.f = f
+	}
+	how := syscall.LOCK_EX
+	if l.shared {
+		how = syscall.LOCK_SH
+	}
+	err := syscall.Flock(int(l.f.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+		return false, nil
+	}
+	if err != nil {
+		l.f.Close()
+		l.f = nil
+		return false, WrapErrorf(err, "could not lock %v", l.path)
+	}
+	if !l.shared {
+		//Only for LockOwner.  A PID left behind by a crash does no harm, since the kernel has released the flock.
+		l.f.Truncate(0)
+		l.f.WriteAt(pidFileContents(), 0)
+	}
+	return true, nil
+}
+
+// The file isn't deleted, because another process may already have it open, waiting for the lock.  If we deleted
+// it, that process would lock the deleted file while a third process locked a new one.
+func (l *FileLock) unlock() error {
+	if !l.shared {
+		l.f.Truncate(0)
+	}
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	l.f.Close()
+	l.f = nil
+	if err != nil {
+		return WrapErrorf(err, "could not unlock %v", l.path)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package goof
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Breaking a stale lock takes a few milliseconds, so a .break file older than this was left by a crash
+const staleBreakAge = 10 * time.Second
+
+// Try to create the lock file.  If it is there already but its owner has died, delete it and try again.
+func (l *FileLock) tryLock() (bool, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.Write(pidFileContents())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(l.path)
+				return false, WrapErrorf(err, "could not write lock file %v", l.path)
+			}
+			return true, nil
+		}
+		if !os.IsExist(err) {
+			return false, WrapErrorf(err, "could not create lock file %v", l.path)
+		}
+		//An empty file is a lock that is still being made, not a stale one
+		pid := LockOwner(l.path)
+		if pid <= 0 || processAlive(pid) {
+			return false, nil
+		}
+		removeStaleLock(l.path, pid)
+	}
+	return false, nil
+}
+
+// Delete the lock file if it still belongs to the dead process pid.  Only one process may do this at a time.
+// Otherwise one could delete the stale lock and take a new one, and then a second process, which saw the same stale
+// PID, would delete the new lock.  Processes take turns by creating path+".break".
+func removeStaleLock(path string, pid int) {
+	breaker := path + ".break"
+	f, err := os.OpenFile(breaker, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if info, err := os.Stat(breaker); err == nil && time.Since(info.ModTime()) > staleBreakAge {
+			os.Remove(breaker)
+		}
+		return
+	}
+	f.Close()
+	defer os.Remove(breaker)
+	if LockOwner(path) == pid {
+		os.Remove(path)
+	}
+}
+
+func (l *FileLock) unlock() error {
+	if err := os.Remove(l.path); err != nil {
+		return WrapErrorf(err, "could not remove lock file %v", l.path)
+	}
+	return nil
+}
+
+// Is there a process with this PID?  On Windows, FindProcess fails if there isn't.  Elsewhere it always works, so
+// send signal 0, which checks without disturbing the process.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		p.Release()
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
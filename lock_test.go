@@ -0,0 +1,47 @@
+package goof
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLockExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	l, err := LockFile(path, FileLockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := LockOwner(path); pid != os.Getpid() {
+		t.Errorf("LockOwner is %v, want %v", pid, os.Getpid())
+	}
+	if _, err := LockFile(path, FileLockOptions{Timeout: 50 * time.Millisecond}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second lock: got %v, want ErrLocked", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = LockFile(path, FileLockOptions{Timeout: -1})
+	if err != nil {
+		t.Fatalf("lock after unlock: %v", err)
+	}
+	l.Unlock()
+	l.Unlock()
+}
+
+// A lock file left behind by a process that has gone doesn't block anyone
+func TestFileLockLeftBehind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	//PIDs this high aren't handed out
+	if err := ioutil.WriteFile(path, []byte("2147483646\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LockFile(path, FileLockOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Unlock()
+}
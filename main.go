@@ -9,8 +9,7 @@ package goof
 import (
 	"bufio"
 	"bytes"
-	"compress/bzip2"
-	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -28,6 +27,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -124,35 +124,19 @@ func Seq(min, max int) []int {
 }
 
 // Opens a file or stdin (if filename is "").  Can open compressed files, and can decompress stdin.
-// Compression is "bz2" or "gz".  Pass "" as a filename to read stdin.
+// Compression is "gz", "zlib", "bz2", "xz", "zst", "lz4", "pgz", "pzst", "parallel" or "none".  If it is "", or
+// anything else, the format is worked out from the data and the filename.  Pass "" as a filename to read stdin.  See
+// OpenInputE for reading URLs and archive members.
+//
+// Calls log.Fatal if the file can't be opened.  Use OpenInputE to get an error instead, and to be able to close the file.
 func OpenInput(filename string, compression string) io.Reader {
-	var f *os.File
-	var err error
-
-	var inReader io.Reader
-	if filename == "" {
-		f = os.Stdin
-	} else {
-		f, err = os.Open(filename)
-		if err != nil {
-			log.Fatalf("Error opening file: %v", err)
-		}
-		//defer f.Close()
-	}
-
-	inReader = bufio.NewReader(f)
-
-	if (strings.HasSuffix(filename, "gz") || compression == "gz") && (!strings.HasSuffix(filename, "bz2")) {
-		inReader, err = gzip.NewReader(f)
-		if err != nil {
-			log.Fatalf("Error ungzipping file: %v", err)
-		}
+	if !compressionModes[compression] {
+		compression = ""
 	}
-
-	if strings.HasSuffix(filename, "bz2") || compression == "bz2" {
-		inReader = bzip2.NewReader(f)
+	inReader, err := OpenInputE(filename, compression)
+	if err != nil {
+		log.Fatalf("Error opening file: %v", err)
 	}
-
 	return inReader
 }
 
@@ -177,6 +161,8 @@ func AbsInt(x int) int {
 	return x
 }
 
+// Opens a file with OpenInput, with a 128MB read buffer.  To read lines from many files without the memory cost,
+// use NewLineScanner.
 func OpenBufferedInput(filename string, compression string) *bufio.Reader {
 	return bufio.NewReaderSize(OpenInput(filename, compression), 134217728)
 }
@@ -186,7 +172,7 @@ func OpenBufferedInput(filename string, compression string) *bufio.Reader {
 // are delivered per message in the channel.  This routine does no buffering, however the wrapped process can use buffers, so you still might not get prompt
 // delivery of your data.  In general, most programs will use line buffering unless you can force them not to.
 //
-// Channel length is the buffer length of the go pipes
+// Channel length is the buffer length of the go pipes.  To start a program on a new pseudo-terminal, use WrapPTY.
 func WrapHandle(fileHandle uintptr, channel_length int) (chan []byte, chan []byte) {
 	stdinQ := make(chan []byte, channel_length)
 	stdoutQ := make(chan []byte, channel_length)
@@ -203,22 +189,7 @@ func WrapHandle(fileHandle uintptr, channel_length int) (chan []byte, chan []byt
 		}
 	}()
 
-	go func() {
-		var data []byte = make([]byte, 1024*1024)
-		for {
-			count, _ := pty.Read(data)
-			//if err != nil {
-			//log.Fatal(err)
-			//}
-
-			if count > 0 {
-				//log.Printf("read %v bytes from pty: %v,%v\n", count, string(data[:count]), []byte(data[:count]))
-				//log.Println("read from process:", data)
-				stdoutQ <- data[:count]
-			}
-
-		}
-	}()
+	go StreamReader(pty, stdoutQ, StreamOptions{})
 
 	return stdinQ, stdoutQ
 }
@@ -240,7 +211,8 @@ func WrapProc(pathToProgram string, channel_length int) (chan []byte, chan []byt
 // are delivered per message in the channel.  This routine does no buffering, however the wrapped process can use buffers, so you still might not get prompt
 // delivery of your data.  In general, most programs will use line buffering unless you can force them not to.
 //
-// Channel length is the buffer length of the go pipes
+// Channel length is the buffer length of the go pipes.  To find out when the program exits, or to stop it, use WrapCmdContext instead.  WrapCmdContext
+// kills the program and everything it started when its context is cancelled or times out.
 func WrapCmd(cmd *exec.Cmd, channel_length int) (chan []byte, chan []byte, chan []byte) {
 
 	stdinQ := make(chan []byte, channel_length)
@@ -271,54 +243,23 @@ func WrapCmd(cmd *exec.Cmd, channel_length int) (chan []byte, chan []byte, chan
 				//log.Println("sent to process:", []byte(data))
 				stdin.Write(data)
 			}
-			//time.Sleep(10 * time.Millisecond)
 		}
 	}()
-	rdout := bufio.NewReader(out)
-	go func() {
-		var data []byte = make([]byte, 1024*1024)
-		for {
-
-			if rdout.Buffered() > 0 {
-				//log.Printf("%v characters ready to read from stdout:", rdout.Buffered())
-			}
-
-			count, _ := rdout.Read(data)
-			/*if err != nil {
-				log.Fatal(fmt.Sprintf("Could not read from process: %v.  %v\n", cmd.Path, err))
-			}*/
-			if count > 0 {
-				//log.Printf("read %v bytes from process: %v,%v\n", count, string(data[:count]), []byte(data[:count]))
-
-				//log.Println("read from process:", data)
-				stdoutQ <- data[:count]
-			}
-
-			time.Sleep(10 * time.Millisecond) //FIXME why does this loop not block?
 
-		}
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		StreamReader(out, stdoutQ, StreamOptions{})
 	}()
-	rderr := bufio.NewReader(errPipe)
 	go func() {
-		var data []byte = make([]byte, 1024)
-		for {
-
-			if rdout.Buffered() > 0 {
-				//log.Printf("%v characters ready to read from stderr:", rderr.Buffered())
-			}
-
-			count, _ := rderr.Read(data)
-			/*if err != nil {
-				log.Fatal(fmt.Sprintf("Could not read from process: %v.  %v\n", cmd.Path, err))
-			}*/
-			if count > 0 {
-				//log.Println("read from process:", data)
-				stderrQ <- data[:count]
-			}
-
-			time.Sleep(10 * time.Millisecond) //FIXME why does this loop not block?
-
-		}
+		defer readers.Done()
+		StreamReader(errPipe, stderrQ, StreamOptions{})
+	}()
+	go func() {
+		//Reap the process once it has closed its output
+		readers.Wait()
+		cmd.Wait()
 	}()
 	return stdinQ, stdoutQ, stderrQ
 }
@@ -361,14 +302,16 @@ func getBody(response *http.Response, url string) ([]byte, bool) {
 
 }
 
+// Shared by SimpleGet and OpenInputE, so connections can be reused
+var httpTransport = &http.Transport{
+	MaxIdleConns:        2,
+	IdleConnTimeout:     10 * time.Second,
+	DisableCompression:  true,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
 func SimpleGet(path string) ([]byte, error) {
-	tr := &http.Transport{
-		MaxIdleConns:        2,
-		IdleConnTimeout:     10 * time.Second,
-		DisableCompression:  true,
-		TLSHandshakeTimeout: 10 * time.Second,
-	}
-	client := http.Client{Transport: tr}
+	client := http.Client{Transport: httpTransport}
 	req, err := http.NewRequest("GET", path, nil)
 	response, err := client.Do(req)
 	if response != nil && response.Body != nil {
@@ -422,6 +365,7 @@ func Exists(path string) bool {
 }
 
 // Write text at the end of a file.  Note that the file is opened and closed on each call, so it's not a good choice for logging..
+// Use NewAppendWriter for that.
 func AppendStringToFile(path, text string) error {
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
@@ -437,6 +381,8 @@ func AppendStringToFile(path, text string) error {
 }
 
 // List all files in a directory, and recursively in its subdirectories
+//
+// The list includes dir itself, and anything that can't be read is silently left out.  Use Walk for more control.
 func LslR(dir string) []string {
 	out := []string{}
 	walkHandler := func(path string, info os.FileInfo, err error) error {
@@ -477,7 +423,7 @@ func IsDir(path string) bool {
 	return f != nil && f.IsDir()
 }
 
-// Calculate the MD5sum of a file
+// Calculate the MD5sum of a file.  Use HashFile for other algorithms.
 func Hash_file_md5(filePath string) (string, error) {
 	//Initialize variable returnMD5String now in case an error has to be returned
 	var returnMD5String string
@@ -509,15 +455,43 @@ func Hash_file_md5(filePath string) (string, error) {
 
 }
 
-// Run a command, wait for it to finish and then return stdout
+// Run a command, wait for it to finish and then return stdout.  If the command fails, stderr is returned instead.  Use Run to get both, and the exit code.
 func QuickCommand(cmd *exec.Cmd) (string, error) {
+	return QuickCommandContext(context.Background(), cmd)
+}
+
+// Like QuickCommand, but if ctx is cancelled or times out, the command and everything it started are killed, see
+// KillTree.  (exec.CommandContext only kills the command itself, and anything it started can keep it from finishing.)
+//
+// If ctx can be cancelled, the command is started in a new process group (see NewProcessGroup), so that anything it
+// leaves running in the background is killed too.
+func QuickCommandContext(ctx context.Context, cmd *exec.Cmd) (string, error) {
+	if ctx.Done() != nil {
+		NewProcessGroup(cmd)
+	}
 	in := strings.NewReader("")
 	cmd.Stdin = in
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	var err bytes.Buffer
 	cmd.Stderr = &err
-	res := cmd.Run()
+	if res := cmd.Start(); res != nil {
+		return err.String(), res
+	}
+	guard := newReapGuard(cmd)
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			guard.killTree()
+		case <-finished:
+		}
+	}()
+	res := guard.wait()
+	close(finished)
+	if ctx.Err() != nil {
+		return err.String(), WrapErrorf(ctx.Err(), "%v was stopped", cmd.Args)
+	}
 	//fmt.Printf("Command result: %v\n", res)
 	ret := out.String()
 	if res != nil {
@@ -665,7 +639,7 @@ func ReadOrMakeConfig(filename string, default_config string) map[string]interfa
 	if err != nil {
 		log.Printf("Could not read config file, writing new file and returning default values(%v)", err)
 		data = []byte(default_config)
-		err := ioutil.WriteFile(filename, []byte(default_config), 0644)
+		err := WriteFileAtomic(filename, []byte(default_config), 0644)
 		if err != nil {
 			log.Printf("Could not write new config file, returning default values(%v)", err)
 		}
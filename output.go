@@ -0,0 +1,276 @@
+package goof
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// Filename suffixes for each format we can write
+var outputSuffixes = map[string]string{
+	".gz": "gz", ".tgz": "gz", ".gzip": "gz",
+	".zlib": "zlib", ".zz": "zlib",
+	".xz": "xz", ".txz": "xz",
+	".zst": "zst", ".zstd": "zst",
+	".lz4": "lz4",
+}
+
+// Create a file, or use stdout (if filename is "" or "-"), and compress everything written to it.  This is the
+// counterpart of OpenInputE.
+//
+// Compression is one of "gz", "zlib", "xz", "zst", "lz4" or "none".  If compression is "", it is chosen from the
+// filename suffix, e.g. ".gz", and if there isn't one, the output is not compressed.
+//
+// You must call Close, or the output will be incomplete.  Close finishes the compression and closes the file (but
+// not stdout).
+func OpenOutput(filename string, compression string) (io.WriteCloser, error) {
+	if filename == "" || filename == "-" {
+		nothing := func() error { return nil }
+		return compressOutput(os.Stdout, filename, compression, nothing, nothing)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, WrapErrorf(err, "could not create %v", filename)
+	}
+	w, err := compressOutput(f, filename, compression, f.Close, f.Close)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Like OpenOutput, but the data is written to a temporary file in the same directory, which replaces filename when
+// you call Close.  Anyone reading filename sees either the old file or the complete new one, never half of it.
+//
+// If a write fails, Close discards the temporary file and returns the error, leaving the old file in place.  Call
+// Abort instead of Close to give up on the new file.
+func OpenOutputAtomic(filename string, compression string) (*AtomicOutput, error) {
+	af, err := CreateAtomic(filename, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w, err := compressOutput(af, filename, compression, af.Close, af.Abort)
+	if err != nil {
+		af.Abort()
+		return nil, err
+	}
+	return &AtomicOutput{WriteCloser: w, file: af}, nil
+}
+
+// The writer returned by OpenOutputAtomic
+type AtomicOutput struct {
+	io.WriteCloser
+	file *AtomicFile
+}
+
+// Throw away everything written so far, and leave the original file untouched
+func (a *AtomicOutput) Abort() error {
+	err := a.file.Abort()
+	//Shut down the compressor.  It can't write anything now.
+	a.WriteCloser.Close()
+	return err
+}
+
+// Wrap w in a compressor, chosen as described in OpenOutput.  closeFile is called after the compressor is closed, or
+// abortFile if closing the compressor fails.
+func compressOutput(w io.Writer, filename, compression string, closeFile, abortFile func() error) (io.WriteCloser, error) {
+	if compression == "" {
+		compression = "none"
+		if c, ok := outputSuffixes[strings.ToLower(filepath.Ext(filename))]; ok {
+			compression = c
+		}
+	}
+
+	buffered := bufio.NewWriterSize(w, 64*1024)
+	var c io.WriteCloser
+	var err error
+	switch compression {
+	case "none":
+		c = nopWriteCloser{buffered}
+	case "gz":
+		c = gzip.NewWriter(buffered)
+	case "zlib":
+		c = zlib.NewWriter(buffered)
+	case "xz":
+		c, err = xz.NewWriter(buffered)
+	case "zst":
+		c, err = zstd.NewWriter(buffered)
+	case "lz4":
+		c = lz4.NewWriter(buffered)
+	default:
+		return nil, NewErrorf("unknown compression %q for %v", compression, filename)
+	}
+	if err != nil {
+		return nil, WrapErrorf(err, "could not start %v compression for %v", compression, filename)
+	}
+	return &outputCloser{Writer: c, closers: []func() error{c.Close, buffered.Flush, closeFile}, abort: abortFile}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Closes a stack of writers, from the top down.  Stops at the first error, and calls abort instead of closing the
+// rest, so a broken file is never committed.
+type outputCloser struct {
+	io.Writer
+	closers []func() error
+	abort   func() error
+}
+
+func (c *outputCloser) Close() error {
+	for _, close := range c.closers {
+		if err := close(); err != nil {
+			c.abort()
+			return err
+		}
+	}
+	return nil
+}
+
+// A file that appears at its final path only when it is closed successfully.  Until then, the data goes to a
+// temporary file in the same directory.  Create one with CreateAtomic.
+type AtomicFile struct {
+	path string
+	perm os.FileMode
+	tmp  *os.File
+	err  error
+	done bool
+}
+
+// Start writing a file atomically.  If the file already exists, the new file gets the same permissions, otherwise it
+// gets perm.
+func CreateAtomic(path string, perm os.FileMode) (*AtomicFile, error) {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp-")
+	if err != nil {
+		return nil, WrapErrorf(err, "could not create temporary file for %v", path)
+	}
+	return &AtomicFile{path: path, perm: perm, tmp: tmp}, nil
+}
+
+// Write to the temporary file.  If a write fails, the file will not be committed.
+func (a *AtomicFile) Write(p []byte) (int, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	n, err := a.tmp.Write(p)
+	if err != nil {
+		a.err = WrapErrorf(err, "could not write %v", a.tmp.Name())
+	}
+	return n, a.err
+}
+
+// Flush the data to disk, and move the temporary file to the final path.  If anything went wrong, the temporary file
+// is removed, and the original file is left untouched.
+func (a *AtomicFile) Close() error {
+	if a.done {
+		return a.err
+	}
+	a.done = true
+	if a.err == nil {
+		a.err = a.commit()
+	}
+	if a.err != nil {
+		a.tmp.Close()
+		os.Remove(a.tmp.Name())
+	}
+	return a.err
+}
+
+func (a *AtomicFile) commit() error {
+	if err := a.tmp.Chmod(a.perm); err != nil {
+		return WrapErrorf(err, "could not set permissions on %v", a.tmp.Name())
+	}
+	if err := a.tmp.Sync(); err != nil {
+		return WrapErrorf(err, "could not flush %v to disk", a.tmp.Name())
+	}
+	if err := a.tmp.Close(); err != nil {
+		return WrapErrorf(err, "could not close %v", a.tmp.Name())
+	}
+	if err := os.Rename(a.tmp.Name(), a.path); err != nil {
+		return WrapErrorf(err, "could not replace %v", a.path)
+	}
+	syncDir(filepath.Dir(a.path))
+	return nil
+}
+
+// Throw away the temporary file, and leave the original file untouched
+func (a *AtomicFile) Abort() error {
+	if a.done {
+		return nil
+	}
+	a.done = true
+	a.err = NewErrorf("aborted")
+	a.tmp.Close()
+	return os.Remove(a.tmp.Name())
+}
+
+// Flush a directory to disk, so that renames in it survive a crash.  Not all platforms can do this, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// Like ioutil.WriteFile, but the data goes to a temporary file in the same directory, which is flushed to disk and
+// then renamed over path.  After a crash, path holds either the old contents or the new ones, never a mix.  If path
+// already exists, it keeps its permissions instead of getting perm.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	af, err := CreateAtomic(path, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := af.Write(data); err != nil {
+		af.Abort()
+		return err
+	}
+	return af.Close()
+}
+
+// Read path, pass the contents to update, and write what it returns back with WriteFileAtomic.  If path doesn't exist,
+// update gets nil.  If update returns an error, the file is left alone.
+//
+// Other processes calling UpdateFile on the same path wait their turn, so nobody's changes are lost.  The lock is a
+// FileLock on path+".lock", so it doesn't stop anyone writing the file some other way.  A process that crashes while
+// holding it doesn't block the others, see FileLock.
+func UpdateFile(path string, update func([]byte) ([]byte, error)) error {
+	lock, err := LockFile(path+".lock", FileLockOptions{})
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return WrapErrorf(err, "could not read %v", path)
+	}
+	data, err = update(data)
+	if err != nil {
+		return WrapErrorf(err, "could not update %v", path)
+	}
+	return WriteFileAtomic(path, data, 0644)
+}
@@ -0,0 +1,61 @@
+package goof
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func increment(data []byte) ([]byte, error) {
+	n, _ := strconv.Atoi(string(data))
+	return []byte(strconv.Itoa(n + 1)), nil
+}
+
+func TestUpdateFileConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "count")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := UpdateFile(path, increment); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "20" {
+		t.Fatalf("count is %q, want 20", data)
+	}
+}
+
+func TestUpdateFileError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	ioutil.WriteFile(path, []byte("old"), 0644)
+	failure := errors.New("no")
+	err := UpdateFile(path, func([]byte) ([]byte, error) { return []byte("new"), failure })
+	if !errors.Is(err, failure) {
+		t.Fatalf("got %v, want the update's error", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "old" {
+		t.Fatalf("file is %q, want it untouched", data)
+	}
+}
+
+// A lock file left by a process that crashed mid-update doesn't block later updates
+func TestUpdateFileAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path+".lock", []byte("2147483646\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateFile(path, increment); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "1" {
+		t.Fatalf("file is %q, want 1", data)
+	}
+}
@@ -0,0 +1,430 @@
+package goof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Parallel decompression cuts the compressed data into segments at gzip member or zstd frame boundaries, and
+// decompresses the segments on all CPUs at once.  The segments are delivered in order, so the output is the same as
+// reading the file with one decompressor.
+//
+// Files made by bgzip have the block sizes in their headers, and zstd frames can be walked without decompressing them,
+// so those are split exactly.  Other multi-member gzip files are split wherever something looks like a gzip header.
+// A segment is only used if it decompresses cleanly and ends exactly where the next one starts, so a bad guess costs
+// time but never corrupts the output.  If a guess turns out to be wrong, or no boundary turns up, the rest of the file
+// is decompressed normally, on one CPU.
+//
+// Splitting only pays off when there is more than one CPU to decompress on, and more than one member to split at, so
+// with GOMAXPROCS=1, or when the input turns out to be a single member, the whole file is decompressed normally.
+const (
+	parallelSegmentSize = 1 << 20  // Compressed bytes per segment
+	parallelFirstSearch = 4 << 20  // If the first boundary isn't in this much data, it's probably a single member
+	parallelMaxSearch   = 64 << 20 // Give up looking for a boundary after this much data
+	parallelReadSize    = 1 << 20
+)
+
+// Finds frame boundaries in compressed data
+type frameSplitter interface {
+	// Find the first boundary at or after target in buf, which starts at a boundary.  Returns -1 if there isn't one
+	// yet.  When next finds a boundary, the caller cuts buf there.
+	next(buf []byte, target int) int
+}
+
+// A piece of the compressed data, and what it decompressed to
+type segment struct {
+	raw  []byte
+	data []byte
+	err  error
+	done chan struct{}
+}
+
+type parallelReader struct {
+	src    io.Reader
+	split  frameSplitter
+	decode func([]byte) ([]byte, error)
+	serial func(io.Reader) (io.Reader, error) // Start an ordinary decompressor, for when we have to give up
+
+	order    chan *segment
+	halt     chan struct{}
+	halted   sync.Once
+	slots    chan struct{}
+	mu       sync.Mutex     // Held while checking halt and starting a decode, so Close can't miss one
+	decoding sync.WaitGroup // Segments being decompressed
+	leftover []byte         // Data the splitter read but didn't use, when it was halted or gave up
+	srcErr   error
+	giveUp   bool
+
+	out      []byte
+	fallback io.Reader
+	err      error
+	closers  []func()
+}
+
+func newParallelReader(src io.Reader, split frameSplitter, decode func([]byte) ([]byte, error), serial func(io.Reader) (io.Reader, error)) *parallelReader {
+	workers := runtime.GOMAXPROCS(0)
+	p := &parallelReader{
+		src:    src,
+		split:  split,
+		decode: decode,
+		serial: serial,
+		order:  make(chan *segment, workers),
+		halt:   make(chan struct{}),
+		slots:  make(chan struct{}, workers),
+	}
+	if workers < 2 {
+		//Read goes straight to the ordinary decompressor
+		p.giveUp = true
+		close(p.order)
+		return p
+	}
+	go p.splitAll()
+	return p
+}
+
+// Read a gzip stream, decompressing members in parallel
+func newParallelGzipReader(src io.Reader) *parallelReader {
+	return newParallelReader(src, &gzipSplitter{}, gunzipSegment, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	})
+}
+
+// Read a zstd stream, decompressing frames in parallel
+func newParallelZstdReader(src io.Reader) (*parallelReader, error) {
+	d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(runtime.NumCPU()))
+	if err != nil {
+		return nil, err
+	}
+	decode := func(raw []byte) ([]byte, error) {
+		return d.DecodeAll(raw, make([]byte, 0, 4*len(raw)))
+	}
+	p := newParallelReader(src, zstdSplitter{}, decode, nil)
+	p.closers = append(p.closers, d.Close)
+	p.serial = func(r io.Reader) (io.Reader, error) {
+		s, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		p.closers = append(p.closers, s.Close)
+		return s, nil
+	}
+	return p, nil
+}
+
+// Cut the source into segments, and start decompressing them
+func (p *parallelReader) splitAll() {
+	defer close(p.order)
+	buf := []byte{}
+	eof := false
+	first := true
+	for {
+		cut := p.split.next(buf, parallelSegmentSize)
+		if cut < 0 && eof {
+			if first {
+				p.leftover, p.giveUp = buf, true
+				return
+			}
+			cut = len(buf)
+		}
+		if cut == 0 {
+			return
+		}
+		if cut < 0 {
+			if len(buf) >= parallelMaxSearch || first && len(buf) >= parallelFirstSearch {
+				p.leftover, p.giveUp = buf, true
+				return
+			}
+			select {
+			case <-p.halt:
+				p.leftover = buf
+				return
+			default:
+			}
+			chunk := make([]byte, parallelReadSize)
+			n, err := io.ReadFull(p.src, chunk)
+			buf = append(buf, chunk[:n]...)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				p.srcErr = err
+				return
+			}
+			continue
+		}
+
+		first = false
+		seg := &segment{raw: buf[:cut:cut], done: make(chan struct{})}
+		buf = append([]byte{}, buf[cut:]...)
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.halt:
+			p.leftover = append(seg.raw, buf...)
+			return
+		}
+		p.mu.Lock()
+		select {
+		case <-p.halt:
+			p.mu.Unlock()
+			<-p.slots
+			p.leftover = append(seg.raw, buf...)
+			return
+		default:
+		}
+		p.decoding.Add(1)
+		p.mu.Unlock()
+		select {
+		case p.order <- seg:
+		case <-p.halt:
+			p.decoding.Done()
+			<-p.slots
+			p.leftover = append(seg.raw, buf...)
+			return
+		}
+		go func() {
+			defer p.decoding.Done()
+			defer func() { <-p.slots }()
+			seg.data, seg.err = p.decode(seg.raw)
+			close(seg.done)
+		}()
+	}
+}
+
+func (p *parallelReader) Read(b []byte) (int, error) {
+	for len(p.out) == 0 {
+		if p.fallback != nil {
+			return p.fallback.Read(b)
+		}
+		if p.err != nil {
+			return 0, p.err
+		}
+		seg, ok := <-p.order
+		if !ok {
+			switch {
+			case p.srcErr != nil:
+				p.err = p.srcErr
+			case p.giveUp:
+				p.startSerial(nil)
+			default:
+				p.err = io.EOF
+			}
+			continue
+		}
+		<-seg.done
+		if seg.err != nil {
+			p.startSerial(seg.raw)
+			continue
+		}
+		p.out = seg.data
+	}
+	n := copy(b, p.out)
+	p.out = p.out[n:]
+	return n, nil
+}
+
+// Stop splitting, and decompress everything from raw onwards with an ordinary decompressor
+func (p *parallelReader) startSerial(raw []byte) {
+	p.halted.Do(func() { close(p.halt) })
+	parts := []io.Reader{bytes.NewReader(raw)}
+	for seg := range p.order {
+		parts = append(parts, bytes.NewReader(seg.raw))
+	}
+	parts = append(parts, bytes.NewReader(p.leftover))
+	if p.srcErr == nil {
+		parts = append(parts, p.src)
+	}
+	r, err := p.serial(io.MultiReader(parts...))
+	if err != nil {
+		p.err = err
+		return
+	}
+	p.fallback = r
+}
+
+// Stop splitting, and wait for any segments still being decompressed before closing the decompressors they use
+func (p *parallelReader) Close() error {
+	p.mu.Lock()
+	p.halted.Do(func() { close(p.halt) })
+	p.mu.Unlock()
+	p.decoding.Wait()
+	for _, c := range p.closers {
+		c()
+	}
+	return nil
+}
+
+// Decompress one or more whole gzip members.  Fails unless the data ends exactly at the end of a member.
+func gunzipSegment(raw []byte) ([]byte, error) {
+	//bytes.Reader is an io.ByteReader, so gzip won't read past the end of each member
+	br := bytes.NewReader(raw)
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(make([]byte, 0, 4*len(raw)))
+	for {
+		zr.Multistream(false)
+		if _, err := io.Copy(out, zr); err != nil {
+			return nil, err
+		}
+		if br.Len() == 0 {
+			return out.Bytes(), nil
+		}
+		if err := zr.Reset(br); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Splits gzip data at member boundaries.  It follows bgzip block sizes as far as it can, and then looks for things
+// that look like gzip headers.
+type gzipSplitter struct {
+	scanned int
+}
+
+func (g *gzipSplitter) next(buf []byte, target int) int {
+	//bgzip blocks say how long they are
+	pos := 0
+	for pos < target && pos < len(buf) {
+		size := bgzfBlockSize(buf[pos:])
+		if size == 0 {
+			break
+		}
+		pos += size
+	}
+	switch {
+	case pos > len(buf):
+		return -1
+	case pos >= target:
+		g.scanned = 0
+		return pos
+	case pos > 0 && len(buf)-pos < 18: //The next block header hasn't arrived yet
+		return -1
+	}
+
+	from := g.scanned
+	if from < target {
+		from = target
+	}
+	for i := from; i+10 <= len(buf); i++ {
+		if buf[i] == 0x1f && gzipHeaderAt(buf[i:]) {
+			g.scanned = 0
+			return i
+		}
+	}
+	g.scanned = len(buf) - 9
+	return -1
+}
+
+// A gzip member header that looks sensible.  Needs 10 bytes.
+func gzipHeaderAt(b []byte) bool {
+	return b[0] == 0x1f && b[1] == 0x8b && b[2] == 8 && b[3]&0xe0 == 0 &&
+		(b[8] == 0 || b[8] == 2 || b[8] == 4) && (b[9] <= 13 || b[9] == 255)
+}
+
+// The size of the bgzip block at the start of b, or 0 if it isn't one (or the header is incomplete)
+func bgzfBlockSize(b []byte) int {
+	if len(b) < 18 || !gzipHeaderAt(b) || b[3]&4 == 0 {
+		return 0
+	}
+	xlen := int(binary.LittleEndian.Uint16(b[10:]))
+	extra := b[12:]
+	if len(extra) > xlen {
+		extra = extra[:xlen]
+	}
+	for len(extra) >= 4 {
+		slen := int(binary.LittleEndian.Uint16(extra[2:]))
+		if extra[0] == 'B' && extra[1] == 'C' && slen == 2 && len(extra) >= 6 {
+			return int(binary.LittleEndian.Uint16(extra[4:])) + 1
+		}
+		if len(extra) < 4+slen {
+			break
+		}
+		extra = extra[4+slen:]
+	}
+	return 0
+}
+
+// Splits zstd data at frame boundaries, by reading the frame and block headers
+type zstdSplitter struct{}
+
+func (zstdSplitter) next(buf []byte, target int) int {
+	pos := 0
+	for pos < target {
+		size := zstdFrameSize(buf[pos:])
+		if size <= 0 {
+			return -1
+		}
+		pos += size
+	}
+	return pos
+}
+
+// The length of the zstd frame at the start of b.  Returns 0 if the frame isn't complete, and -1 if it isn't a frame.
+func zstdFrameSize(b []byte) int {
+	if len(b) < 8 {
+		return 0
+	}
+	magic := binary.LittleEndian.Uint32(b)
+	if magic&0xfffffff0 == 0x184d2a50 { //Skippable frame
+		size := 8 + int(binary.LittleEndian.Uint32(b[4:]))
+		if size > len(b) {
+			return 0
+		}
+		return size
+	}
+	if magic != 0xfd2fb528 {
+		return -1
+	}
+
+	desc := b[4]
+	singleSegment := desc&0x20 != 0
+	pos := 5
+	if !singleSegment {
+		pos++ //Window descriptor
+	}
+	pos += []int{0, 1, 2, 4}[desc&3] //Dictionary ID
+	switch desc >> 6 {               //Frame content size
+	case 0:
+		if singleSegment {
+			pos++
+		}
+	case 1:
+		pos += 2
+	case 2:
+		pos += 4
+	case 3:
+		pos += 8
+	}
+
+	for {
+		if pos+3 > len(b) {
+			return 0
+		}
+		header := int(b[pos]) | int(b[pos+1])<<8 | int(b[pos+2])<<16
+		last := header&1 != 0
+		size := header >> 3
+		switch (header >> 1) & 3 {
+		case 1: //RLE block
+			size = 1
+		case 3:
+			return -1
+		}
+		pos += 3 + size
+		if last {
+			break
+		}
+	}
+	if desc&4 != 0 { //Checksum
+		pos += 4
+	}
+	if pos > len(b) {
+		return 0
+	}
+	return pos
+}
@@ -0,0 +1,128 @@
+package goof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Write a gzip file made of many members, like the output of pigz or bgzip, and return its path and uncompressed size
+func writeMultiMemberGzip(b testing.TB, members, memberSize int) (string, int64) {
+	words := []string{"alpha ", "beta ", "gamma ", "delta ", "epsilon ", "zeta ", "eta ", "theta ", "\n"}
+	rng := rand.New(rand.NewSource(1))
+	path := filepath.Join(b.TempDir(), "multi.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	var total int64
+	var plain bytes.Buffer
+	for m := 0; m < members; m++ {
+		plain.Reset()
+		for plain.Len() < memberSize {
+			plain.WriteString(words[rng.Intn(len(words))])
+		}
+		total += int64(plain.Len())
+		zw := gzip.NewWriter(f)
+		if _, err := zw.Write(plain.Bytes()); err != nil {
+			b.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return path, total
+}
+
+// Compare the parallel gzip reader with compress/gzip on a multi-member file.  The parallel reader only wins with
+// more than one CPU, e.g. go test -bench ParallelGzip -cpu 1,4
+func BenchmarkParallelGzip(b *testing.B) {
+	path, size := writeMultiMemberGzip(b, 64, 1024*1024)
+	for _, compression := range []string{"gz", "pgz"} {
+		b.Run(compression, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				r, err := OpenInputE(path, compression)
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := io.Copy(ioutil.Discard, r)
+				r.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n != size {
+					b.Fatalf("read %v bytes, want %v", n, size)
+				}
+			}
+		})
+	}
+}
+
+// With one CPU, or a single gzip member, the parallel reader hands everything to compress/gzip
+func TestParallelGzipFallsBackToSerial(t *testing.T) {
+	multi, multiSize := writeMultiMemberGzip(t, 16, 1024*1024)
+	single, singleSize := writeMultiMemberGzip(t, 1, 1024*1024)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	for _, c := range []struct {
+		name  string
+		path  string
+		size  int64
+		procs int
+		split bool
+	}{
+		{"multi member, 1 CPU", multi, multiSize, 1, false},
+		{"single member, 2 CPUs", single, singleSize, 2, false},
+		{"multi member, 2 CPUs", multi, multiSize, 2, true},
+	} {
+		runtime.GOMAXPROCS(c.procs)
+		f, err := os.Open(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := newParallelGzipReader(f)
+		n, err := io.Copy(ioutil.Discard, p)
+		p.Close()
+		f.Close()
+		if err != nil || n != c.size {
+			t.Errorf("%v: read %v bytes, %v, want %v", c.name, n, err, c.size)
+		}
+		if split := p.fallback == nil; split != c.split {
+			t.Errorf("%v: split is %v, want %v", c.name, split, c.split)
+		}
+	}
+}
+
+// Closing part way through waits for the frames still being decompressed, before closing the decoder they use
+func TestParallelZstdCloseWhileDecoding(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	frame := enc.EncodeAll(bytes.Repeat([]byte("some text to compress\n"), 100000), nil)
+	var data []byte
+	for i := 0; i < 32; i++ {
+		data = append(data, frame...)
+	}
+	for i := 0; i < 10; i++ {
+		p, err := newParallelZstdReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Read(make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+		p.Close()
+	}
+}
@@ -0,0 +1,235 @@
+package goof
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Make a command for a pipeline.  It's exec.Command with a shorter name.
+func Cmd(name string, args ...string) *exec.Cmd {
+	return exec.Command(name, args...)
+}
+
+// A series of commands, with the STDOUT of each connected to the STDIN of the next, like a shell pipeline.  No shell
+// is involved, so there is no quoting to get wrong.  Build one with Pipe.
+type Pipeline struct {
+	Stages []*exec.Cmd
+
+	stdinFile  string
+	stdoutFile string
+	appendOut  bool
+	stdout     io.Writer
+	stderr     io.Writer
+}
+
+// The result of a pipeline.  The embedded RunResult holds the output of the last command, the stderr of every
+// command, and the exit status of the pipeline.  Stages has the result of each command.
+//
+// As with the shell's pipefail option, the exit status of the pipeline is the exit status of the last command that
+// failed, or 0 if they all succeeded.
+type PipelineResult struct {
+	RunResult
+	Stages []RunResult
+}
+
+// Connect commands into a pipeline.  e.g.
+//
+//	goof.Pipe(goof.Cmd("grep", "x"), goof.Cmd("sort"), goof.Cmd("uniq", "-c")).Run(goof.RunOptions{Stdin: input})
+func Pipe(stages ...*exec.Cmd) *Pipeline {
+	return &Pipeline{Stages: stages}
+}
+
+// Read the first command's input from a file, like "< path"
+func (p *Pipeline) StdinFile(path string) *Pipeline {
+	p.stdinFile = path
+	return p
+}
+
+// Write the last command's output to a file, like "> path".  The output is not kept in the result.
+func (p *Pipeline) StdoutFile(path string) *Pipeline {
+	p.stdoutFile = path
+	p.appendOut = false
+	return p
+}
+
+// Add the last command's output to the end of a file, like ">> path".  The output is not kept in the result.
+func (p *Pipeline) AppendStdoutFile(path string) *Pipeline {
+	p.stdoutFile = path
+	p.appendOut = true
+	return p
+}
+
+// Send the last command's output to w.  The output is not kept in the result.
+func (p *Pipeline) StdoutWriter(w io.Writer) *Pipeline {
+	p.stdout = w
+	return p
+}
+
+// Copy every command's stderr to w, as well as keeping it in the result
+func (p *Pipeline) StderrWriter(w io.Writer) *Pipeline {
+	p.stderr = w
+	return p
+}
+
+// Run the pipeline and wait for every command to finish.  opts works the same way as for Run, and applies to every
+// command.  opts.Stdin is the input for the first command, unless StdinFile was used.  If neither is set, the first
+// command keeps its own Stdin, like RunCmd, or gets empty input if it doesn't have one.
+//
+// The error is nil only if every command exited with status 0.
+func (p *Pipeline) Run(opts RunOptions) (*PipelineResult, error) {
+	return p.RunContext(context.Background(), opts)
+}
+
+// Like Run, but all the commands are killed if ctx is cancelled
+func (p *Pipeline) RunContext(ctx context.Context, opts RunOptions) (*PipelineResult, error) {
+	if len(p.Stages) == 0 {
+		return nil, NewErrorf("empty pipeline")
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	//Files we have to close once the commands have started (pipes) or finished (redirections)
+	var afterStart, afterWait []*os.File
+	closeAll := func(files []*os.File) {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	defer func() {
+		closeAll(afterWait)
+	}()
+
+	first, last := p.Stages[0], p.Stages[len(p.Stages)-1]
+	switch {
+	case p.stdinFile != "":
+		f, err := os.Open(p.stdinFile)
+		if err != nil {
+			return nil, WrapErrorf(err, "could not open pipeline input %v", p.stdinFile)
+		}
+		afterWait = append(afterWait, f)
+		first.Stdin = f
+	case opts.Stdin != nil:
+		first.Stdin = opts.Stdin
+	case first.Stdin == nil:
+		first.Stdin = strings.NewReader("")
+	}
+
+	res := &PipelineResult{Stages: make([]RunResult, len(p.Stages))}
+	var stdout bytes.Buffer
+	combined := &syncBuffer{}
+	switch {
+	case p.stdoutFile != "":
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if p.appendOut {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		f, err := os.OpenFile(p.stdoutFile, flags, 0644)
+		if err != nil {
+			return nil, WrapErrorf(err, "could not open pipeline output %v", p.stdoutFile)
+		}
+		afterWait = append(afterWait, f)
+		last.Stdout = f
+	case p.stdout != nil:
+		last.Stdout = p.stdout
+	default:
+		last.Stdout = io.MultiWriter(&stdout, combined)
+	}
+
+	stderrs := make([]*bytes.Buffer, len(p.Stages))
+	var allStderr syncBuffer
+	for i, cmd := range p.Stages {
+		applyRunOptions(cmd, opts)
+		stderrs[i] = &bytes.Buffer{}
+		writers := []io.Writer{stderrs[i], &allStderr, combined}
+		if p.stderr != nil {
+			writers = append(writers, p.stderr)
+		}
+		cmd.Stderr = io.MultiWriter(writers...)
+		res.Stages[i].Argv = cmd.Args
+		res.Stages[i].ExitCode = -1
+
+		if i < len(p.Stages)-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				closeAll(afterStart)
+				return nil, WrapError(err, "could not create pipe")
+			}
+			cmd.Stdout = w
+			p.Stages[i+1].Stdin = r
+			afterStart = append(afterStart, r, w)
+		}
+	}
+
+	res.Start = time.Now()
+	for i, cmd := range p.Stages {
+		res.Stages[i].Start = time.Now()
+		if err := cmd.Start(); err != nil {
+			closeAll(afterStart)
+			for _, started := range p.Stages[:i] {
+				started.Process.Kill()
+				started.Wait()
+			}
+			return nil, WrapErrorf(err, "could not start pipeline stage %v %v", i, cmd.Args)
+		}
+	}
+	//The children have their own copies of the pipes now.  If we keep ours open, nobody ever sees EOF.
+	closeAll(afterStart)
+
+	//Stages are reaped one by one, and the guards make sure a timeout doesn't kill the ones that are gone already
+	guards := make([]*reapGuard, len(p.Stages))
+	for i, cmd := range p.Stages {
+		guards[i] = newReapGuard(cmd)
+	}
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			for _, g := range guards {
+				g.killTree()
+			}
+		case <-finished:
+		}
+	}()
+
+	var failed error
+	for i, cmd := range p.Stages {
+		err := guards[i].wait()
+		stage := &res.Stages[i]
+		stage.End = time.Now()
+		stage.Duration = stage.End.Sub(stage.Start)
+		stage.Stderr = stderrs[i].String()
+		stage.setExitStatus(cmd.ProcessState)
+		if err != nil {
+			failed = WrapErrorf(err, "pipeline stage %v %v failed", i, cmd.Args)
+			res.ExitCode = stage.ExitCode
+			res.Signal = stage.Signal
+		}
+	}
+	close(finished)
+
+	res.Argv = last.Args
+	res.End = time.Now()
+	res.Duration = res.End.Sub(res.Start)
+	res.Stdout = stdout.String()
+	res.Stderr = allStderr.String()
+	res.Combined = combined.String()
+	res.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if failed == nil {
+		res.ExitCode = 0
+	}
+	res.Stages[len(res.Stages)-1].Stdout = res.Stdout
+
+	if ctx.Err() != nil {
+		return res, WrapErrorf(ctx.Err(), "pipeline was stopped after %v", res.Duration)
+	}
+	return res, failed
+}
@@ -5,13 +5,24 @@ import (
 	"syscall"
 )
 
-//Restart the current application
-//Attemtps to read the command line parameters from the current process
-func Restart() {
-	procAttr := new(syscall.ProcAttr)
-	procAttr.Files = []uintptr{0, 1, 2}
-	procAttr.Dir = os.Getenv("PWD")
-	procAttr.Env = os.Environ()
-	exe, _ := os.Executable()
-	syscall.ForkExec(exe, os.Args, procAttr)
+// Peak memory use of a finished process, in bytes
+func maxRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return int64(ru.Maxrss)
+	}
+	return 0
+}
+
+// Let f survive exec, so the new program can use it
+func clearCloseOnExec(f *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_SETFD, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Replace the current process with a new program
+func execReplace(exe string, argv, env []string) error {
+	return syscall.Exec(exe, argv, env)
 }
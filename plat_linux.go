@@ -5,17 +5,43 @@ import (
 	"syscall"
 )
 
-// Restart the current application
-// Attemtps to read the command line parameters from the current process
-func Restart() {
-	argv := os.Args
-	if len(argv) > 0 {
-		argv = argv[1:]
-		os.StartProcess(argv[0], argv, &os.ProcAttr{
-			Dir:   ".",
-			Env:   os.Environ(),
-			Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
-			Sys:   &syscall.SysProcAttr{},
-		})
+// Peak memory use of a finished process, in bytes
+func maxRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return int64(ru.Maxrss) * 1024
 	}
+	return 0
+}
+
+// Let f survive exec, so the new program can use it
+func clearCloseOnExec(f *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_SETFD, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Replace the current process with a new program
+func execReplace(exe string, argv, env []string) error {
+	return syscall.Exec(exe, argv, env)
+}
+
+// Run an ioctl without calling f.Fd(), which would switch the file to blocking mode
+func ioctl(f *os.File, request, arg uintptr) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
 }
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package goof
+
+import (
+	"errors"
+	"os"
+)
+
+// Peak memory use of a finished process.  Not available on this platform.
+func maxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
+
+var errNoExec = errors.New("replacing the current process is not supported on this platform")
+
+func clearCloseOnExec(f *os.File) error {
+	return errNoExec
+}
+
+func execReplace(exe string, argv, env []string) error {
+	return errNoExec
+}
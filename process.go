@@ -0,0 +1,179 @@
+package goof
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// A running program, started by WrapCmdContext.
+//
+// Write to Stdin to send data to the program, and close Stdin to close the program's STDIN.  Stdout and Stderr receive
+// the program's output, and are closed when the program closes them (usually when it exits).  You must keep reading
+// Stdout and Stderr, otherwise the program will block when the channel fills up.
+type Process struct {
+	Stdin  chan []byte
+	Stdout chan []byte
+	Stderr chan []byte
+	Cmd    *exec.Cmd
+
+	done     chan struct{}
+	guard    *reapGuard
+	exitCode int
+	err      error
+	pty      *os.File
+}
+
+// Starts cmd in the background and returns a Process connected to its STDIN, STDOUT and STDERR.
+//
+// Unlike WrapCmd, errors are returned instead of calling log.Fatal, and the output channels are closed when the program
+// closes its output.  If ctx is cancelled before the program exits, the program and its descendants are killed.
+//
+// Channel length is the buffer length of the go pipes
+func WrapCmdContext(ctx context.Context, cmd *exec.Cmd, channel_length int) (*Process, error) {
+	return WrapCmdStream(ctx, cmd, StreamOptions{ChannelLength: channel_length})
+}
+
+// Like WrapCmdContext, but opts controls how the output is split into messages, see StreamOptions
+func WrapCmdStream(ctx context.Context, cmd *exec.Cmd, opts StreamOptions) (*Process, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, WrapError(err, "could not open stdin pipe")
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, WrapError(err, "could not open stdout pipe")
+	}
+	errPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, WrapError(err, "could not open stderr pipe")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, WrapErrorf(err, "could not start command %v", cmd.Path)
+	}
+
+	p := &Process{
+		Stdin:  make(chan []byte, opts.ChannelLength),
+		Stdout: make(chan []byte, opts.ChannelLength),
+		Stderr: make(chan []byte, opts.ChannelLength),
+		Cmd:    cmd,
+		done:   make(chan struct{}),
+		guard:  newReapGuard(cmd),
+	}
+
+	go p.feed(stdin, stdin.Close)
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		StreamReader(out, p.Stdout, opts)
+		close(p.Stdout)
+	}()
+	go func() {
+		defer readers.Done()
+		StreamReader(errPipe, p.Stderr, opts)
+		close(p.Stderr)
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.KillTree()
+		case <-p.done:
+		}
+	}()
+
+	go func() {
+		//cmd.Wait closes the pipes, so all reads must finish first
+		readers.Wait()
+		p.finish(p.guard.wait())
+	}()
+
+	return p, nil
+}
+
+// Copy messages from the Stdin channel to the program until the channel is closed or the program exits.  closeInput
+// is called when the channel is closed.
+func (p *Process) feed(stdin io.Writer, closeInput func() error) {
+	for {
+		select {
+		case data, ok := <-p.Stdin:
+			if !ok {
+				closeInput()
+				return
+			}
+			if len(data) != 0 {
+				if _, err := stdin.Write(data); err != nil {
+					return
+				}
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Record the result of cmd.Wait and wake everyone waiting on the process
+func (p *Process) finish(err error) {
+	p.exitCode = -1
+	if p.Cmd.ProcessState != nil {
+		p.exitCode = p.Cmd.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && p.exitCode >= 0 {
+		//The program exited by itself, the exit code says everything
+		err = nil
+	}
+	p.err = err
+	close(p.done)
+}
+
+// Returns a channel that is closed when the program has exited and its output channels have been closed
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait for the program to exit, and return its exit code.
+//
+// err is nil if the program exited by itself, even with a non-zero exit code.  If the program was killed by a signal,
+// the exit code is -1 and err describes the signal.
+func (p *Process) Wait() (exitCode int, err error) {
+	<-p.done
+	return p.exitCode, p.err
+}
+
+// Send a signal to the program
+func (p *Process) Signal(sig os.Signal) error {
+	if p.Cmd.Process == nil {
+		return NewErrorf("process not started")
+	}
+	return p.Cmd.Process.Signal(sig)
+}
+
+// Kill the program immediately
+func (p *Process) Kill() error {
+	if p.Cmd.Process == nil {
+		return NewErrorf("process not started")
+	}
+	return p.Cmd.Process.Kill()
+}
+
+// Kill the program and everything it started, see KillTree
+func (p *Process) KillTree() error {
+	if p.Cmd.Process == nil {
+		return NewErrorf("process not started")
+	}
+	return p.guard.killTree()
+}
+
+// Change the window size of the terminal.  Only works for processes started with WrapPTY.
+func (p *Process) Resize(rows, cols int) error {
+	if p.pty == nil {
+		return NewErrorf("process does not have a terminal")
+	}
+	return setWinSize(p.pty, rows, cols)
+}
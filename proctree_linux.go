@@ -0,0 +1,170 @@
+package goof
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Linux reports CPU times in clock ticks, which are 1/100th of a second on every platform Go supports
+const clockTicks = 100
+
+// What /proc knows about a process
+type ProcInfo struct {
+	Pid        int
+	PPid       int
+	Pgid       int
+	Command    string // The executable name, truncated to 15 characters by the kernel
+	State      string // R running, S sleeping, D disk sleep, Z zombie, T stopped, ...
+	Threads    int
+	UserTime   time.Duration
+	SystemTime time.Duration
+	RSS        int64 // Resident memory in bytes
+}
+
+// Set up cmd so that it starts in a new process group.  The program and everything it starts can then be killed
+// together, even if some of them have been orphaned.  Call before starting cmd.
+//
+// The program won't receive signals from the terminal (e.g. ^C), so make sure you stop it yourself.
+func NewProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if !cmd.SysProcAttr.Setsid { //A new session is a new group already
+		cmd.SysProcAttr.Setpgid = true
+	}
+}
+
+// Read /proc/<pid>/stat
+func ProcStat(pid int) (*ProcInfo, error) {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return nil, WrapErrorf(err, "could not read stats for process %v", pid)
+	}
+	//The command is in brackets, and can contain spaces and brackets, so find the last one
+	s := string(data)
+	open, end := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if open < 0 || end < open {
+		return nil, NewErrorf("could not parse stats for process %v: %q", pid, s)
+	}
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 22 {
+		return nil, NewErrorf("could not parse stats for process %v: %q", pid, s)
+	}
+	//fields[0] is field 3 in proc(5)
+	field := func(n int) int64 {
+		v, _ := strconv.ParseInt(fields[n-3], 10, 64)
+		return v
+	}
+	return &ProcInfo{
+		Pid:        pid,
+		Command:    s[open+1 : end],
+		State:      fields[0],
+		PPid:       int(field(4)),
+		Pgid:       int(field(5)),
+		UserTime:   time.Duration(field(14)) * time.Second / clockTicks,
+		SystemTime: time.Duration(field(15)) * time.Second / clockTicks,
+		Threads:    int(field(20)),
+		RSS:        field(24) * int64(os.Getpagesize()),
+	}, nil
+}
+
+// List every process on the system
+func allProcs() ([]*ProcInfo, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, WrapError(err, "could not list processes")
+	}
+	out := []*ProcInfo{}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		//Processes can exit while we are looking
+		if info, err := ProcStat(pid); err == nil {
+			out = append(out, info)
+		}
+	}
+	return out, nil
+}
+
+// Find the children of pid, their children, and so on.  Parents come before their children in the list.
+func Descendants(pid int) ([]int, error) {
+	procs, err := allProcs()
+	if err != nil {
+		return nil, err
+	}
+	children := map[int][]int{}
+	for _, p := range procs {
+		children[p.PPid] = append(children[p.PPid], p.Pid)
+	}
+	out := []int{}
+	queue := children[pid]
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = append(queue[1:], children[next]...)
+		out = append(out, next)
+	}
+	return out, nil
+}
+
+// Send sig to pid and all its descendants.  If pid leads a process group (see NewProcessGroup), the whole group gets
+// the signal too, which catches descendants that have been orphaned.
+//
+// The processes are stopped while we find them, so they can't start new ones behind our back.
+func KillTree(pid int, sig syscall.Signal) error {
+	syscall.Kill(pid, syscall.SIGSTOP)
+	pids, err := Descendants(pid)
+	for _, child := range pids {
+		syscall.Kill(child, syscall.SIGSTOP)
+	}
+	//They might have forked between listing and stopping
+	if more, err2 := Descendants(pid); err2 == nil {
+		pids = more
+	}
+
+	if pgid, gerr := syscall.Getpgid(pid); gerr == nil && pgid == pid {
+		syscall.Kill(-pgid, sig)
+		syscall.Kill(-pgid, syscall.SIGCONT)
+	}
+	for _, p := range append([]int{pid}, pids...) {
+		syscall.Kill(p, sig)
+		syscall.Kill(p, syscall.SIGCONT)
+	}
+	return err
+}
+
+// Linux can wait for a process to exit without reaping it, see reapGuard
+const waitsWithoutReaping = true
+
+// Block until the process has exited, but leave it as a zombie, so its PID isn't reused until cmd.Wait reaps it
+func waitExited(pid int) {
+	const pPID = 1 //P_PID from waitid(2)
+	var info [128]byte
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid), uintptr(unsafe.Pointer(&info[0])),
+			syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		if errno != syscall.EINTR {
+			return
+		}
+	}
+}
+
+// The process group cmd leads, if it was started in a new one, otherwise 0.  Call after starting cmd.
+func ledGroup(cmd *exec.Cmd) int {
+	a := cmd.SysProcAttr
+	if a == nil || !a.Setsid && !(a.Setpgid && a.Pgid == 0) {
+		return 0
+	}
+	return cmd.Process.Pid
+}
+
+func killGroup(pgid int) {
+	syscall.Kill(-pgid, syscall.SIGKILL)
+}
@@ -0,0 +1,64 @@
+//go:build !linux
+// +build !linux
+
+package goof
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+var errNoProc = errors.New("process trees are only supported on linux")
+
+// What /proc knows about a process.  Only available on Linux.
+type ProcInfo struct {
+	Pid        int
+	PPid       int
+	Pgid       int
+	Command    string
+	State      string
+	Threads    int
+	UserTime   time.Duration
+	SystemTime time.Duration
+	RSS        int64
+}
+
+// Does nothing on this platform
+func NewProcessGroup(cmd *exec.Cmd) {}
+
+// Only available on Linux
+func ProcStat(pid int) (*ProcInfo, error) {
+	return nil, NewError(errNoProc, "")
+}
+
+// Only available on Linux
+func Descendants(pid int) ([]int, error) {
+	return nil, NewError(errNoProc, "")
+}
+
+// Only the process itself can be killed on this platform, its descendants are left alone
+func KillTree(pid int, sig syscall.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if sig == syscall.SIGKILL {
+		return p.Kill()
+	}
+	return p.Signal(sig)
+}
+
+// There's no portable way to wait for a process without reaping it, see reapGuard
+const waitsWithoutReaping = false
+
+func waitExited(pid int) {}
+
+// Process groups aren't used on this platform
+func ledGroup(cmd *exec.Cmd) int {
+	return 0
+}
+
+func killGroup(pgid int) {}
@@ -0,0 +1,97 @@
+package goof
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Starts a program on a new pseudo-terminal, and returns a Process connected to it.
+//
+// Programs that check whether they are talking to a terminal will behave as if a user were typing at them, so REPLs
+// print their prompts, and most programs flush their output after every line instead of buffering it.  The terminal
+// merges STDOUT and STDERR, so everything arrives on Stdout, and Stderr is closed immediately.  Closing Stdin sends an
+// end-of-file (^D) to the program.
+//
+// The terminal starts at 24 rows by 80 columns, use Resize to change it.  Only supported on Linux.
+//
+// Channel length is the buffer length of the go pipes
+func WrapPTY(cmd *exec.Cmd, channel_length int) (*Process, error) {
+	return WrapPTYStream(context.Background(), cmd, StreamOptions{ChannelLength: channel_length})
+}
+
+// Like WrapPTY, but the program is killed if ctx is cancelled, and opts controls how the output is split into messages
+func WrapPTYStream(ctx context.Context, cmd *exec.Cmd, opts StreamOptions) (*Process, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	if err := setWinSize(master, 24, 80); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, WrapError(err, "could not set terminal size")
+	}
+	err = startOnPTY(cmd, slave)
+	//The child has its own copy now.  We must close ours, or we never see EOF.
+	slave.Close()
+	if err != nil {
+		master.Close()
+		return nil, WrapErrorf(err, "could not start command %v", cmd.Path)
+	}
+
+	p := &Process{
+		Stdin:  make(chan []byte, opts.ChannelLength),
+		Stdout: make(chan []byte, opts.ChannelLength),
+		Stderr: make(chan []byte, opts.ChannelLength),
+		Cmd:    cmd,
+		done:   make(chan struct{}),
+		guard:  newReapGuard(cmd),
+		pty:    master,
+	}
+	close(p.Stderr)
+
+	go p.feed(master, func() error {
+		_, err := master.Write([]byte{4})
+		return err
+	})
+
+	var reader sync.WaitGroup
+	reader.Add(1)
+	go func() {
+		defer reader.Done()
+		StreamReader(ptyReader{master}, p.Stdout, opts)
+		close(p.Stdout)
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.KillTree()
+		case <-p.done:
+		}
+	}()
+
+	go func() {
+		reader.Wait()
+		err := p.guard.wait()
+		master.Close()
+		p.finish(err)
+	}()
+
+	return p, nil
+}
+
+// Reads the master side of a terminal, turning the error we get when the child closes the terminal into EOF
+type ptyReader struct {
+	f *os.File
+}
+
+func (r ptyReader) Read(b []byte) (int, error) {
+	n, err := r.f.Read(b)
+	if err != nil && isPTYClosed(err) {
+		err = io.EOF
+	}
+	return n, err
+}
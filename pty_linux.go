@@ -0,0 +1,67 @@
+package goof
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// Allocate a new pseudo-terminal.  Returns the master, which we read and write, and the slave, which the child uses as
+// its terminal.
+func openPTY() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, WrapError(err, "could not open /dev/ptmx")
+	}
+
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, WrapError(err, "could not unlock pty")
+	}
+	var num uint32
+	if err := ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&num))); err != nil {
+		master.Close()
+		return nil, nil, WrapError(err, "could not get pty number")
+	}
+
+	name := "/dev/pts/" + strconv.Itoa(int(num))
+	slave, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, WrapErrorf(err, "could not open %v", name)
+	}
+	return master, slave, nil
+}
+
+// Start cmd in a new session, with slave as its controlling terminal
+func startOnPTY(cmd *exec.Cmd, slave *os.File) error {
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0 //The child's stdin
+	return cmd.Start()
+}
+
+// Set the window size of the terminal
+func setWinSize(f *os.File, rows, cols int) error {
+	ws := struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}{uint16(rows), uint16(cols), 0, 0}
+	return ioctl(f, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+// Translate the error from reading the master once the child has gone.  Linux returns EIO instead of EOF.
+func isPTYClosed(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return err == syscall.EIO
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package goof
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var errNoPTY = errors.New("pseudo-terminals are only supported on linux")
+
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, NewError(errNoPTY, "")
+}
+
+func startOnPTY(cmd *exec.Cmd, slave *os.File) error {
+	return NewError(errNoPTY, "")
+}
+
+func setWinSize(f *os.File, rows, cols int) error {
+	return NewError(errNoPTY, "")
+}
+
+func isPTYClosed(err error) bool {
+	return false
+}
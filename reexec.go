@@ -0,0 +1,162 @@
+package goof
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The environment variable that tells a re-executed program which file descriptors it inherited
+const inheritedFilesEnv = "GOOF_INHERITED_FDS"
+
+// Settings for Reexec.  The zero value starts a new copy of the program with the same arguments, working directory
+// and environment.
+type ReexecOptions struct {
+	// Replace the current process with the new one (using exec), instead of starting a new process.  The process ID
+	// stays the same, and Reexec only returns if it fails.  Not available on Windows.
+	Replace bool
+
+	// Open files to hand to the new program, e.g. from ListenerFile.  The new program gets them from InheritedFiles
+	// or InheritedListeners, in the same order.
+	Files []*os.File
+
+	// Extra "KEY=value" entries for the environment
+	Env []string
+}
+
+// Start the current program again, with the same arguments, working directory and environment.
+//
+// To upgrade a server without dropping connections, pass its listeners in opts.Files, start the new copy, and then
+// stop accepting connections and exit once the new copy is ready.  The new copy calls InheritedListeners to pick up
+// the listeners.
+//
+// Returns the new process, or nil if opts.Replace is set.
+func Reexec(opts ReexecOptions) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, WrapError(err, "could not find the current executable")
+	}
+	env := []string{}
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, inheritedFilesEnv+"=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env, opts.Env...)
+
+	if opts.Replace {
+		fds := []string{}
+		for _, f := range opts.Files {
+			if err := clearCloseOnExec(f); err != nil {
+				return nil, WrapErrorf(err, "could not pass %v to the new process", f.Name())
+			}
+			fds = append(fds, strconv.Itoa(int(f.Fd())))
+		}
+		if len(fds) > 0 {
+			env = append(env, inheritedFilesEnv+"="+strings.Join(fds, ","))
+		}
+		return nil, WrapErrorf(execReplace(exe, os.Args, env), "could not exec %v", exe)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, WrapError(err, "could not find the working directory")
+	}
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	if len(opts.Files) > 0 {
+		fds := []string{}
+		for i, f := range opts.Files {
+			files = append(files, f)
+			fds = append(fds, strconv.Itoa(3+i))
+		}
+		env = append(env, inheritedFilesEnv+"="+strings.Join(fds, ","))
+	}
+	proc, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Dir:   dir,
+		Env:   env,
+		Files: files,
+	})
+	if err != nil {
+		return nil, WrapErrorf(err, "could not start %v", exe)
+	}
+	return proc, nil
+}
+
+// Restart the current application.  A new copy of the program is started with the same arguments, working directory
+// and environment.  The current program keeps running, so it should exit after calling Restart.
+func Restart() {
+	Reexec(ReexecOptions{})
+}
+
+// Get a file for a listener, to pass to Reexec.  Works for TCP and Unix listeners.
+func ListenerFile(l net.Listener) (*os.File, error) {
+	filer, ok := l.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, NewErrorf("listener %T does not have a file", l)
+	}
+	f, err := filer.File()
+	if err != nil {
+		return nil, WrapErrorf(err, "could not get file for listener %v", l.Addr())
+	}
+	return f, nil
+}
+
+var inherited struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files []*os.File
+}
+
+// The files passed to this program by Reexec, in the same order.  Returns nil if the program was not started by Reexec.
+// Files that InheritedListeners has turned into listeners are left out.
+func InheritedFiles() []*os.File {
+	inherited.once.Do(func() {
+		list := os.Getenv(inheritedFilesEnv)
+		if list == "" {
+			return
+		}
+		//Don't hand them to our own children by accident
+		os.Unsetenv(inheritedFilesEnv)
+		for _, s := range strings.Split(list, ",") {
+			fd, err := strconv.Atoi(s)
+			if err != nil {
+				continue
+			}
+			inherited.files = append(inherited.files, os.NewFile(uintptr(fd), "inherited-"+s))
+		}
+	})
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	if inherited.files == nil {
+		return nil
+	}
+	return append([]*os.File{}, inherited.files...)
+}
+
+// The listeners passed to this program by Reexec, in the same order.  Returns nil if the program was not started by
+// Reexec.
+//
+// The listeners have their own copies of the descriptors, so the inherited files are closed, and InheritedFiles stops
+// returning them.  Each listener is only returned once.  If a file isn't a listener, it and the ones after it are left
+// alone.
+func InheritedListeners() ([]net.Listener, error) {
+	InheritedFiles()
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	out := []net.Listener{}
+	for len(inherited.files) > 0 {
+		f := inherited.files[0]
+		l, err := net.FileListener(f)
+		if err != nil {
+			return out, WrapErrorf(err, "inherited file %v is not a listener", f.Name())
+		}
+		f.Close()
+		inherited.files = inherited.files[1:]
+		out = append(out, l)
+	}
+	return out, nil
+}
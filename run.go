@@ -0,0 +1,221 @@
+package goof
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Everything we know about a finished command
+type RunResult struct {
+	Argv     []string
+	Stdout   string
+	Stderr   string
+	Combined string // Stdout and Stderr, interleaved in the order they were written
+	ExitCode int    // -1 if the command was killed by a signal, or could not be started
+	Signal   os.Signal
+	TimedOut bool
+
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+
+	UserTime   time.Duration
+	SystemTime time.Duration
+	MaxRSS     int64 // Peak memory use in bytes, where the platform reports it
+}
+
+// Settings for Run.  The zero value runs the command in the current directory, with the current environment, no time
+// limit, and empty input.
+type RunOptions struct {
+	Timeout time.Duration
+	Dir     string
+	Env     []string  // "KEY=value" entries, added to the current environment, replacing any existing KEY
+	Stdin   io.Reader // Defaults to empty input
+
+	// Start the command in its own process group (see NewProcessGroup), so that nothing it starts survives a timeout
+	NewProcessGroup bool
+}
+
+// Run a command and wait for it to finish.  The first element of argv is the program, the rest are program arguments.
+//
+// Unlike QuickCommand, you get stdout and stderr both, along with the exit code and timing.  The error is nil only if
+// the command ran and exited with status 0.  The result is returned whenever the command started, even if it failed,
+// so you can report what went wrong.
+func Run(argv []string, opts RunOptions) (*RunResult, error) {
+	return RunContext(context.Background(), argv, opts)
+}
+
+// Like Run, but the command is killed if ctx is cancelled
+func RunContext(ctx context.Context, argv []string, opts RunOptions) (*RunResult, error) {
+	if len(argv) == 0 {
+		return nil, NewErrorf("no command given")
+	}
+	return RunCmd(ctx, exec.Command(argv[0], argv[1:]...), opts)
+}
+
+// Like RunContext, but runs a command you have already set up.  Settings in opts override the ones in cmd.
+// cmd.Stdout and cmd.Stderr are replaced.
+//
+// If the command runs out of time, or ctx is cancelled, the command and all its descendants are killed.
+func RunCmd(ctx context.Context, cmd *exec.Cmd, opts RunOptions) (*RunResult, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	applyRunOptions(cmd, opts)
+	if opts.Stdin != nil {
+		cmd.Stdin = opts.Stdin
+	} else if cmd.Stdin == nil {
+		cmd.Stdin = strings.NewReader("")
+	}
+
+	res := &RunResult{Argv: cmd.Args, ExitCode: -1}
+	var stdout, stderr bytes.Buffer
+	combined := &syncBuffer{}
+	cmd.Stdout = io.MultiWriter(&stdout, combined)
+	cmd.Stderr = io.MultiWriter(&stderr, combined)
+
+	res.Start = time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, WrapErrorf(err, "could not start %v", cmd.Args)
+	}
+	guard := newReapGuard(cmd)
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			guard.killTree()
+		case <-finished:
+		}
+	}()
+	err := guard.wait()
+	close(finished)
+	res.End = time.Now()
+	res.Duration = res.End.Sub(res.Start)
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+	res.Combined = combined.String()
+	res.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+
+	res.setExitStatus(cmd.ProcessState)
+
+	if ctx.Err() != nil {
+		return res, WrapErrorf(ctx.Err(), "%v was stopped after %v", cmd.Args, res.Duration)
+	}
+	if err != nil {
+		if tail := lastLine(res.Stderr); tail != "" {
+			return res, WrapErrorf(err, "%v failed: %v", cmd.Args, tail)
+		}
+		return res, WrapErrorf(err, "%v failed", cmd.Args)
+	}
+	return res, nil
+}
+
+// Apply the directory and environment settings from opts to cmd
+func applyRunOptions(cmd *exec.Cmd, opts RunOptions) {
+	if opts.Dir != "" {
+		cmd.Dir = opts.Dir
+	}
+	if len(opts.Env) > 0 {
+		env := cmd.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		cmd.Env = append(append([]string{}, env...), opts.Env...)
+	}
+	if opts.NewProcessGroup {
+		NewProcessGroup(cmd)
+	}
+}
+
+// Copy the exit code, signal and resource usage from a finished process
+func (r *RunResult) setExitStatus(ps *os.ProcessState) {
+	if ps == nil {
+		return
+	}
+	r.ExitCode = ps.ExitCode()
+	r.UserTime = ps.UserTime()
+	r.SystemTime = ps.SystemTime()
+	r.MaxRSS = maxRSS(ps)
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		r.Signal = ws.Signal()
+	}
+}
+
+// A bytes.Buffer that can be written from several goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// The last non-empty line of s, which is usually the most useful part of an error message
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
+
+// Waits for a command, and kills its process tree on request, but never once the command has been reaped.  After
+// that its PID can be reused, and KillTree would hit an unrelated process.  A command that leads its own process group
+// can still have its group killed after that, since the group ID isn't reused while anything is left in the group.
+type reapGuard struct {
+	cmd    *exec.Cmd
+	pgid   int // The process group the command leads, or 0
+	mu     sync.Mutex
+	reaped bool
+}
+
+// Make a guard for a command that has been started
+func newReapGuard(cmd *exec.Cmd) *reapGuard {
+	return &reapGuard{cmd: cmd, pgid: ledGroup(cmd)}
+}
+
+// Like cmd.Wait
+func (g *reapGuard) wait() error {
+	if waitsWithoutReaping {
+		//The PID stays ours until cmd.Wait reaps it, so a kill that gets the lock before us is safe
+		waitExited(g.cmd.Process.Pid)
+		g.mu.Lock()
+		g.reaped = true
+		g.mu.Unlock()
+	}
+	return g.cmd.Wait()
+}
+
+// Kill the command and its descendants with KillTree, if the command hasn't been reaped
+func (g *reapGuard) killTree() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.reaped {
+		//Orphans left in the group can still be holding our pipes open
+		if g.pgid != 0 {
+			killGroup(g.pgid)
+		}
+		return nil
+	}
+	if !waitsWithoutReaping {
+		//KillTree only kills the process itself here, and os.Process knows not to once it has been reaped
+		return g.cmd.Process.Kill()
+	}
+	return KillTree(g.cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,51 @@
+package goof
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// The shell exits straight away, leaving sleep holding stdout open.  The timeout has to kill sleep through the process
+// group, since the shell is already reaped by then.
+const orphanScript = "sleep 30 & echo started"
+
+func TestRunTimeoutKillsOrphanedGrandchild(t *testing.T) {
+	start := time.Now()
+	res, err := Run([]string{"sh", "-c", orphanScript}, RunOptions{Timeout: 500 * time.Millisecond, NewProcessGroup: true})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Run took %v, the orphan wasn't killed", elapsed)
+	}
+	if err == nil || !res.TimedOut {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if res.Stdout != "started\n" {
+		t.Errorf("stdout was %q", res.Stdout)
+	}
+}
+
+func TestQuickCommandContextKillsOrphanedGrandchild(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := QuickCommandContext(ctx, exec.Command("sh", "-c", orphanScript)); err == nil {
+		t.Error("expected an error from the killed command")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("QuickCommandContext took %v, the orphan wasn't killed", elapsed)
+	}
+}
+
+func TestPipelineTimeoutKillsOrphanedGrandchild(t *testing.T) {
+	first := Cmd("sh", "-c", orphanScript)
+	NewProcessGroup(first)
+	start := time.Now()
+	res, err := Pipe(first, Cmd("cat")).Run(RunOptions{Timeout: 500 * time.Millisecond})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("pipeline took %v, the orphan wasn't killed", elapsed)
+	}
+	if err == nil || !res.TimedOut {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package goof
+
+import (
+	"runtime"
+	"strings"
+)
+
+// Split a command line into words, the way a POSIX shell would, so you can pass it to QC.  Single quotes, double
+// quotes and backslashes work as they do in sh.  Variables are not expanded, use ShellSplitEnv for that.  Pipes,
+// redirections and globs are not special, they are just characters in the words.
+func ShellSplit(s string) ([]string, error) {
+	return ShellSplitEnv(s, nil)
+}
+
+// Like ShellSplit, but expands $VAR and ${VAR} outside single quotes, using getenv (e.g. os.Getenv) to look up values.
+// Unlike the shell, the value of an unquoted variable is not split into more words.
+func ShellSplitEnv(s string, getenv func(string) string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	r := []rune(s)
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			inWord = true
+			if i+1 >= len(r) {
+				return nil, NewErrorf("trailing backslash in %q", s)
+			}
+			i++
+			if r[i] != '\n' { //Backslash-newline is a line continuation
+				word.WriteRune(r[i])
+			}
+		case c == '\'':
+			inWord = true
+			end := indexRune(r, i+1, '\'')
+			if end < 0 {
+				return nil, NewErrorf("unterminated single quote in %q", s)
+			}
+			word.WriteString(string(r[i+1 : end]))
+			i = end
+		case c == '"':
+			inWord = true
+			i++
+			for ; i < len(r) && r[i] != '"'; i++ {
+				switch {
+				case r[i] == '\\' && i+1 < len(r) && strings.ContainsRune("$`\"\\\n", r[i+1]):
+					i++
+					if r[i] != '\n' {
+						word.WriteRune(r[i])
+					}
+				case r[i] == '$' && getenv != nil:
+					i = expandVar(r, i, getenv, &word)
+				default:
+					word.WriteRune(r[i])
+				}
+			}
+			if i >= len(r) {
+				return nil, NewErrorf("unterminated double quote in %q", s)
+			}
+		case c == '$' && getenv != nil:
+			inWord = true
+			i = expandVar(r, i, getenv, &word)
+		default:
+			inWord = true
+			word.WriteRune(c)
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func indexRune(r []rune, start int, c rune) int {
+	for i := start; i < len(r); i++ {
+		if r[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func isVarChar(c rune, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// Expand the variable starting at r[i], which is a '$'.  Returns the index of the last character used.  A '$' that
+// doesn't start a variable name is copied as it is.
+func expandVar(r []rune, i int, getenv func(string) string, word *strings.Builder) int {
+	if i+1 < len(r) && r[i+1] == '{' {
+		end := indexRune(r, i+2, '}')
+		if end > 0 {
+			word.WriteString(getenv(string(r[i+2 : end])))
+			return end
+		}
+	}
+	end := i + 1
+	for end < len(r) && isVarChar(r[end], end == i+1) {
+		end++
+	}
+	if end == i+1 {
+		word.WriteRune('$')
+		return i
+	}
+	word.WriteString(getenv(string(r[i+1 : end])))
+	return end - 1
+}
+
+// Quote a word so that a POSIX shell will read it back unchanged.  Words that don't need quoting are returned as they are.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, c := range s {
+		if !isVarChar(c, false) && !strings.ContainsRune("@%+=:,./-", c) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Quote each argument with ShellQuote and join them into a command line for /bin/sh.  This is the reverse of ShellSplit.
+func ShellJoin(argv []string) string {
+	out := make([]string, len(argv))
+	for i, a := range argv {
+		out[i] = ShellQuote(a)
+	}
+	return strings.Join(out, " ")
+}
+
+// Quote a word so that a Windows program will read it back unchanged, following the rules of CommandLineToArgvW
+func WindowsQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, c := range s {
+		switch c {
+		case '\\':
+			slashes++
+		case '"':
+			//Backslashes before a quote must be doubled, and the quote escaped
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteRune(c)
+	}
+	//Backslashes before the closing quote must be doubled
+	b.WriteString(strings.Repeat(`\`, slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Quote each argument with WindowsQuote, join them, and then escape the result so that cmd.exe passes it through
+// untouched.  Use this to build the command for Shell on Windows, which runs "cmd.exe /c".
+func CmdJoin(argv []string) string {
+	out := make([]string, len(argv))
+	for i, a := range argv {
+		out[i] = WindowsQuote(a)
+	}
+	line := strings.Join(out, " ")
+	var b strings.Builder
+	for _, c := range line {
+		if strings.ContainsRune(`()%!^"<>&|`, c) {
+			b.WriteByte('^')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Join argv into a command line for Shell on the current platform: CmdJoin on Windows, ShellJoin everywhere else
+func ShellCommandLine(argv []string) string {
+	if runtime.GOOS == "windows" {
+		return CmdJoin(argv)
+	}
+	return ShellJoin(argv)
+}
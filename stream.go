@@ -0,0 +1,112 @@
+package goof
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// How StreamReader splits its input into messages
+type Framing int
+
+const (
+	// Send whatever each read returns.  Lowest latency, but messages can split lines or multi-byte characters.
+	FrameChunks Framing = iota
+	// Send one line per message, including the trailing newline
+	FrameLines
+	// Send one message per Delimiter, including the delimiter
+	FrameDelimited
+)
+
+// Settings for StreamReader, WrapCmdStream and friends.  The zero value sends chunks over unbuffered channels.
+type StreamOptions struct {
+	Framing       Framing
+	Delimiter     byte // Used by FrameDelimited
+	ChannelLength int  // Buffer length of the go pipes
+	BufferSize    int  // Size of each read, default 32kb
+	MaxFrame      int  // Lines and delimited frames longer than this are sent in pieces.  0 means no limit
+}
+
+const defaultStreamBuffer = 32 * 1024
+
+var streamBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, defaultStreamBuffer)
+		return &b
+	},
+}
+
+// Reads r until EOF, and sends the data to out, split into messages according to opts.
+//
+// Every message is a newly allocated slice, so the receiver can keep it as long as it likes.  The read blocks until data
+// is available, and the send blocks until the receiver takes the message, so a slow receiver will slow down the writer
+// instead of losing data.  Any partial frame left at EOF is sent as the last message.
+//
+// Returns nil at EOF, or the read error.  out is not closed.
+func StreamReader(r io.Reader, out chan<- []byte, opts StreamOptions) error {
+	switch opts.Framing {
+	case FrameLines:
+		return streamFrames(r, out, '\n', opts)
+	case FrameDelimited:
+		return streamFrames(r, out, opts.Delimiter, opts)
+	default:
+		return streamChunks(r, out, opts)
+	}
+}
+
+func streamChunks(r io.Reader, out chan<- []byte, opts StreamOptions) error {
+	var buf []byte
+	if opts.BufferSize > 0 {
+		buf = make([]byte, opts.BufferSize)
+	} else {
+		pooled := streamBuffers.Get().(*[]byte)
+		defer streamBuffers.Put(pooled)
+		buf = *pooled
+	}
+	for {
+		count, err := r.Read(buf)
+		if count > 0 {
+			data := make([]byte, count)
+			copy(data, buf[:count])
+			out <- data
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func streamFrames(r io.Reader, out chan<- []byte, delim byte, opts StreamOptions) error {
+	size := opts.BufferSize
+	if size <= 0 {
+		size = defaultStreamBuffer
+	}
+	rd := bufio.NewReaderSize(r, size)
+	var frame []byte
+	for {
+		piece, err := rd.ReadSlice(delim)
+		frame = append(frame, piece...)
+		for opts.MaxFrame > 0 && len(frame) > opts.MaxFrame {
+			data := make([]byte, opts.MaxFrame)
+			copy(data, frame)
+			out <- data
+			frame = frame[opts.MaxFrame:]
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if len(frame) > 0 {
+			out <- frame
+			frame = nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
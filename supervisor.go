@@ -0,0 +1,199 @@
+package goof
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// When a supervised program should be restarted
+type RestartPolicy int
+
+const (
+	// Restart the program whenever it exits
+	RestartAlways RestartPolicy = iota
+	// Restart the program only if it exits with a non-zero status, or is killed
+	RestartOnFailure
+	// Run the program once
+	RestartNever
+)
+
+// A program for a Supervisor to run.  Only Name and Argv are required.
+type ChildSpec struct {
+	Name    string
+	Argv    []string
+	Dir     string
+	Env     []string // "KEY=value" entries, added to the current environment
+	Restart RestartPolicy
+
+	MinBackoff time.Duration // Wait before the first restart, doubled after each quick failure.  Default 1 second
+	MaxBackoff time.Duration // Longest wait between restarts.  Default 1 minute
+
+	// Give up if the program is restarted more than MaxRestarts times within RestartWindow.  0 means never give up.
+	MaxRestarts   int
+	RestartWindow time.Duration
+}
+
+// Runs a set of programs, restarts them when they exit, and shuts them down when its context is cancelled.
+//
+// Each line the programs print is sent to Logger, prefixed with the program's name.  On shutdown each program gets
+// SIGTERM, and then SIGKILL if it hasn't exited after GracePeriod.
+type Supervisor struct {
+	Logger      *log.Logger   // Defaults to the standard logger
+	GracePeriod time.Duration // Default 10 seconds
+
+	mu       sync.Mutex
+	children []ChildSpec
+}
+
+// Add a program to the supervisor.  Must be called before Run.
+func (s *Supervisor) Add(spec ChildSpec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.children = append(s.children, spec)
+}
+
+// Start all the programs, and keep them running until ctx is cancelled.  Returns after every program has stopped.
+func (s *Supervisor) Run(ctx context.Context) {
+	s.mu.Lock()
+	children := append([]ChildSpec{}, s.children...)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, spec := range children {
+		wg.Add(1)
+		go func(spec ChildSpec) {
+			defer wg.Done()
+			s.supervise(ctx, spec)
+		}(spec)
+	}
+	wg.Wait()
+}
+
+func (s *Supervisor) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// Run one program, and restart it according to its policy
+func (s *Supervisor) supervise(ctx context.Context, spec ChildSpec) {
+	minBackoff, maxBackoff := spec.MinBackoff, spec.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = time.Second
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+	backoff := minBackoff
+	var restarts []time.Time
+
+	for {
+		started := time.Now()
+		code, err := s.runOnce(ctx, spec)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.logf("[%v] exited: %v", spec.Name, err)
+		} else {
+			s.logf("[%v] exited with status %v", spec.Name, code)
+		}
+
+		failed := err != nil || code != 0
+		if spec.Restart == RestartNever || (spec.Restart == RestartOnFailure && !failed) {
+			return
+		}
+
+		now := time.Now()
+		if spec.MaxRestarts > 0 {
+			recent := []time.Time{}
+			for _, t := range restarts {
+				if spec.RestartWindow <= 0 || now.Sub(t) < spec.RestartWindow {
+					recent = append(recent, t)
+				}
+			}
+			restarts = append(recent, now)
+			if len(restarts) > spec.MaxRestarts {
+				s.logf("[%v] restarted %v times in %v, giving up", spec.Name, spec.MaxRestarts, spec.RestartWindow)
+				return
+			}
+		}
+
+		//A program that ran for a while before exiting starts again with a short wait
+		if now.Sub(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		s.logf("[%v] restarting in %v", spec.Name, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Start the program, log its output, and wait for it to exit or for ctx to be cancelled
+func (s *Supervisor) runOnce(ctx context.Context, spec ChildSpec) (int, error) {
+	if len(spec.Argv) == 0 {
+		return -1, NewErrorf("no command given for %v", spec.Name)
+	}
+	cmd := exec.Command(spec.Argv[0], spec.Argv[1:]...)
+	applyRunOptions(cmd, RunOptions{Dir: spec.Dir, Env: spec.Env})
+
+	//We handle cancellation ourselves, so the program gets a chance to shut down cleanly
+	p, err := WrapCmdStream(context.Background(), cmd, StreamOptions{Framing: FrameLines})
+	if err != nil {
+		return -1, err
+	}
+	close(p.Stdin)
+	s.logf("[%v] started, pid %v", spec.Name, cmd.Process.Pid)
+
+	var output sync.WaitGroup
+	output.Add(2)
+	for _, out := range []chan []byte{p.Stdout, p.Stderr} {
+		go func(out chan []byte) {
+			defer output.Done()
+			for line := range out {
+				s.logf("[%v] %v", spec.Name, strings.TrimRight(string(line), "\r\n"))
+			}
+		}(out)
+	}
+
+	select {
+	case <-p.Done():
+	case <-ctx.Done():
+		s.stop(spec.Name, p)
+	}
+	output.Wait()
+	return p.Wait()
+}
+
+// Ask the program to exit, and kill it if it doesn't
+func (s *Supervisor) stop(name string, p *Process) {
+	grace := s.GracePeriod
+	if grace <= 0 {
+		grace = 10 * time.Second
+	}
+	s.logf("[%v] stopping", name)
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		p.Kill()
+		return
+	}
+	select {
+	case <-p.Done():
+	case <-time.After(grace):
+		s.logf("[%v] did not stop after %v, killing", name, grace)
+		p.Kill()
+	}
+}
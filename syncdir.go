@@ -0,0 +1,362 @@
+package goof
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Something SyncDir did, or would do in a dry run
+type SyncOp int
+
+const (
+	SyncCopy     SyncOp = iota // Copy a file that isn't in the destination
+	SyncUpdate                 // Copy a file that is different in the destination
+	SyncMkdir                  // Create a directory
+	SyncSymlink                // Create or replace a symlink
+	SyncMetadata               // Fix the permissions or modification time of a file or directory
+	SyncDelete                 // Delete something that isn't in the source
+)
+
+func (op SyncOp) String() string {
+	return [...]string{"copy", "update", "mkdir", "symlink", "metadata", "delete"}[op]
+}
+
+// One step of a sync
+type SyncAction struct {
+	Op   SyncOp
+	Path string // Relative to the source and destination, with / as the separator
+}
+
+// Settings for SyncDir.  The zero value copies new and changed files, comparing them by size and modification time,
+// and doesn't delete anything.
+type SyncOptions struct {
+	Checksum bool        // Compare the contents of files with the same size, instead of their modification times
+	Delete   bool        // Delete files and directories in the destination that aren't in the source
+	DryRun   bool        // Work out what to do, but don't do it
+	Workers  int         // How many files to copy at once.  Default is the number of CPUs
+	Walk     WalkOptions // Which files to sync, e.g. Exclude.  Excluded files in the destination are not deleted
+}
+
+// What SyncDir did
+type SyncReport struct {
+	Actions []SyncAction // Sorted by path
+}
+
+// Make dst a copy of src, copying only what has changed.  Permissions, modification times and symlinks are copied.
+// Files are written to a temporary file and renamed into place, so a file in dst is never half-written.
+//
+// SyncDir keeps going when something fails, and returns all the errors it found.  The report lists what was done
+// (or would be done, in a dry run), not counting what failed.
+func SyncDir(src, dst string, opts SyncOptions) (*SyncReport, []error) {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	walkOpts := opts.Walk
+	walkOpts.Types = 0
+	walkOpts.FollowSymlinks = false
+
+	if !IsDir(src) {
+		return &SyncReport{Actions: []SyncAction{}}, []error{NewErrorf("%v is not a directory", src)}
+	}
+	srcEntries, errs := Walk(src, walkOpts)
+	dstInfo := map[string]os.FileInfo{}
+	if IsDir(dst) {
+		dstEntries, dstErrs := Walk(dst, walkOpts)
+		errs = append(errs, dstErrs...)
+		for _, e := range dstEntries {
+			dstInfo[e.Rel] = e.Info
+		}
+	}
+
+	s := &syncer{src: src, dst: dst, opts: opts, report: &SyncReport{Actions: []SyncAction{}}, errs: errs}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		s.fail(WrapErrorf(err, "could not read %v", src))
+		return s.report, s.errs
+	}
+	if !opts.DryRun {
+		if err := os.MkdirAll(dst, writableDir(srcInfo)); err != nil {
+			s.fail(WrapErrorf(err, "could not create %v", dst))
+			return s.report, s.errs
+		}
+		if err := os.Chmod(dst, writableDir(srcInfo)); err != nil {
+			s.fail(WrapErrorf(err, "could not set permissions on %v", dst))
+			return s.report, s.errs
+		}
+	}
+	s.plan(srcEntries, dstInfo)
+	s.run()
+	if !opts.DryRun {
+		if err := copyMetadata(dst, srcInfo); err != nil {
+			s.fail(err)
+		}
+	}
+
+	sort.Slice(s.report.Actions, func(i, j int) bool { return s.report.Actions[i].Path < s.report.Actions[j].Path })
+	return s.report, s.errs
+}
+
+type syncer struct {
+	src, dst string
+	opts     SyncOptions
+
+	mkdirs, copies, links, fixes, deletes []syncStep
+	opens                                 []syncStep // Existing directories that we can't write into yet
+	dirs                                  []syncStep // Directory permissions and times are set last, since copying changes them
+
+	mu     sync.Mutex
+	report *SyncReport
+	errs   []error
+}
+
+// A planned action, with the source entry it came from
+type syncStep struct {
+	SyncAction
+	info   os.FileInfo
+	report bool // For directories, whether fixing the metadata is worth reporting, as not every directory needs it
+}
+
+func (s *syncer) fail(err error) {
+	s.mu.Lock()
+	s.errs = append(s.errs, err)
+	s.mu.Unlock()
+}
+
+func (s *syncer) done(a SyncAction) {
+	s.mu.Lock()
+	s.report.Actions = append(s.report.Actions, a)
+	s.mu.Unlock()
+}
+
+// Work out what needs doing
+func (s *syncer) plan(srcEntries []WalkEntry, dstInfo map[string]os.FileInfo) {
+	inSrc := map[string]os.FileInfo{}
+	for _, e := range srcEntries {
+		inSrc[e.Rel] = e.Info
+		step := func(op SyncOp) syncStep { return syncStep{SyncAction: SyncAction{op, e.Rel}, info: e.Info} }
+		d, exists := dstInfo[e.Rel]
+		mode := e.Info.Mode()
+		switch {
+		case mode.IsDir():
+			dir := step(SyncMetadata)
+			if !exists || !d.IsDir() {
+				s.mkdirs = append(s.mkdirs, step(SyncMkdir))
+			} else {
+				dir.report = d.Mode().Perm() != mode.Perm()
+				if d.Mode().Perm()&0700 != 0700 {
+					s.opens = append(s.opens, dir)
+				}
+			}
+			s.dirs = append(s.dirs, dir)
+		case mode&os.ModeSymlink != 0:
+			if !exists || d.Mode()&os.ModeSymlink == 0 || !sameLinkTarget(filepath.Join(s.src, e.Rel), filepath.Join(s.dst, e.Rel)) {
+				s.links = append(s.links, step(SyncSymlink))
+			}
+		case mode.IsRegular():
+			switch {
+			case !exists:
+				s.copies = append(s.copies, step(SyncCopy))
+			case !d.Mode().IsRegular() || s.changed(e, d):
+				s.copies = append(s.copies, step(SyncUpdate))
+			case d.Mode().Perm() != mode.Perm() || d.ModTime().Unix() != e.Info.ModTime().Unix():
+				s.fixes = append(s.fixes, step(SyncMetadata))
+			}
+		default:
+			s.fail(NewErrorf("can't sync %v, it is not a file, directory or symlink", e.Path))
+		}
+	}
+
+	if s.opts.Delete {
+		for rel, info := range dstInfo {
+			if _, ok := inSrc[rel]; !ok && !s.parentDeleted(rel, dstInfo, inSrc) {
+				s.deletes = append(s.deletes, syncStep{SyncAction: SyncAction{SyncDelete, rel}, info: info})
+			}
+		}
+	}
+}
+
+func parentRel(rel string) string {
+	if i := strings.LastIndex(rel, "/"); i >= 0 {
+		return rel[:i]
+	}
+	return ""
+}
+
+// Is some parent of rel being deleted already, or replaced by a file or symlink?  Then rel goes with it, and doesn't
+// need its own delete.
+func (s *syncer) parentDeleted(rel string, dstInfo map[string]os.FileInfo, inSrc map[string]os.FileInfo) bool {
+	for p := parentRel(rel); p != ""; p = parentRel(p) {
+		if _, ok := dstInfo[p]; !ok {
+			continue
+		}
+		if src, ok := inSrc[p]; !ok || !src.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// Are the contents of a file different in the destination?
+func (s *syncer) changed(e WalkEntry, d os.FileInfo) bool {
+	if e.Info.Size() != d.Size() {
+		return true
+	}
+	if !s.opts.Checksum {
+		return e.Info.ModTime().Unix() != d.ModTime().Unix()
+	}
+	a, err := HashFile(e.Path, "sha256")
+	if err != nil {
+		return true
+	}
+	b, err := HashFile(filepath.Join(s.dst, filepath.FromSlash(e.Rel)), "sha256")
+	return err != nil || a["sha256"] != b["sha256"]
+}
+
+func sameLinkTarget(a, b string) bool {
+	ta, err := os.Readlink(a)
+	if err != nil {
+		return false
+	}
+	tb, err := os.Readlink(b)
+	return err == nil && ta == tb
+}
+
+// Do the plan, or just report it in a dry run
+func (s *syncer) run() {
+	if s.opts.DryRun {
+		for _, list := range [][]syncStep{s.mkdirs, s.copies, s.links, s.fixes, s.deletes} {
+			for _, step := range list {
+				s.done(step.SyncAction)
+			}
+		}
+		for _, step := range s.dirs {
+			if step.report {
+				s.done(step.SyncAction)
+			}
+		}
+		return
+	}
+
+	//Directories stay writable by us until everything is copied into them, and get their real permissions at the end
+	sort.Slice(s.mkdirs, func(i, j int) bool { return s.mkdirs[i].Path < s.mkdirs[j].Path })
+	for _, step := range s.mkdirs {
+		s.apply(step, s.mkdir)
+	}
+	for _, step := range s.opens {
+		if err := os.Chmod(s.dstPath(step.Path), writableDir(step.info)); err != nil {
+			s.fail(WrapErrorf(err, "could not set permissions on %v", s.dstPath(step.Path)))
+		}
+	}
+
+	jobs := make(chan syncStep)
+	var wg sync.WaitGroup
+	for i := 0; i < s.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for step := range jobs {
+				s.apply(step, s.copy)
+			}
+		}()
+	}
+	for _, step := range s.copies {
+		jobs <- step
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, step := range s.links {
+		s.apply(step, s.symlink)
+	}
+	for _, step := range s.fixes {
+		s.apply(step, s.setMetadata)
+	}
+	for _, step := range s.deletes {
+		s.apply(step, s.delete)
+	}
+
+	//Deepest first, so setting a directory's time isn't undone by changes inside it
+	sort.Slice(s.dirs, func(i, j int) bool { return s.dirs[i].Path > s.dirs[j].Path })
+	for _, step := range s.dirs {
+		if err := s.setMetadata(step); err != nil {
+			s.fail(err)
+		} else if step.report {
+			s.done(step.SyncAction)
+		}
+	}
+}
+
+func (s *syncer) apply(step syncStep, f func(syncStep) error) {
+	if err := f(step); err != nil {
+		s.fail(err)
+		return
+	}
+	s.done(step.SyncAction)
+}
+
+func (s *syncer) srcPath(rel string) string {
+	return filepath.Join(s.src, filepath.FromSlash(rel))
+}
+
+func (s *syncer) dstPath(rel string) string {
+	return filepath.Join(s.dst, filepath.FromSlash(rel))
+}
+
+func (s *syncer) mkdir(step syncStep) error {
+	dst := s.dstPath(step.Path)
+	if info, err := os.Lstat(dst); err == nil && !info.IsDir() {
+		if err := os.Remove(dst); err != nil {
+			return WrapErrorf(err, "could not remove %v", dst)
+		}
+	}
+	if err := os.Mkdir(dst, writableDir(step.info)); err != nil {
+		return WrapErrorf(err, "could not create %v", dst)
+	}
+	return nil
+}
+
+func (s *syncer) copy(step syncStep) error {
+	dst := s.dstPath(step.Path)
+	if err := removeDirInTheWay(dst); err != nil {
+		return err
+	}
+	return copyFile(s.srcPath(step.Path), dst, step.info, CopyOptions{})
+}
+
+func (s *syncer) symlink(step syncStep) error {
+	dst := s.dstPath(step.Path)
+	if err := removeDirInTheWay(dst); err != nil {
+		return err
+	}
+	return copySymlink(s.srcPath(step.Path), dst)
+}
+
+func removeDirInTheWay(path string) error {
+	if info, err := os.Lstat(path); err == nil && info.IsDir() {
+		if err := os.RemoveAll(path); err != nil {
+			return WrapErrorf(err, "could not remove %v", path)
+		}
+	}
+	return nil
+}
+
+func (s *syncer) setMetadata(step syncStep) error {
+	return copyMetadata(s.dstPath(step.Path), step.info)
+}
+
+func (s *syncer) delete(step syncStep) error {
+	dst := s.dstPath(step.Path)
+	if err := os.RemoveAll(dst); err != nil {
+		return WrapErrorf(err, "could not delete %v", dst)
+	}
+	return nil
+}
+
+// The permissions of a directory while we copy into it
+func writableDir(info os.FileInfo) os.FileMode {
+	return info.Mode().Perm() | 0700
+}
@@ -0,0 +1,36 @@
+package goof
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A file in the source where the destination has a directory replaces the directory, and nothing inside it needs
+// deleting separately
+func TestSyncDirFileReplacesDirectory(t *testing.T) {
+	root := t.TempDir()
+	src, dst := filepath.Join(root, "src"), filepath.Join(root, "dst")
+	if err := os.MkdirAll(filepath.Join(dst, "x", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(src, 0755)
+	ioutil.WriteFile(filepath.Join(src, "x"), []byte("file"), 0644)
+	ioutil.WriteFile(filepath.Join(dst, "x", "child"), []byte("old"), 0644)
+	ioutil.WriteFile(filepath.Join(dst, "x", "sub", "grandchild"), []byte("old"), 0644)
+
+	report, errs := SyncDir(src, dst, SyncOptions{Delete: true})
+	if len(errs) > 0 {
+		t.Fatalf("SyncDir failed: %v", errs)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dst, "x"))
+	if err != nil || string(data) != "file" {
+		t.Fatalf("dst/x is %q, %v", data, err)
+	}
+	for _, a := range report.Actions {
+		if a.Op == SyncDelete {
+			t.Errorf("unexpected delete of %v", a.Path)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package goof
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kinds of directory entries, for WalkOptions.Types
+type WalkTypes int
+
+const (
+	WalkFiles    WalkTypes = 1 << iota // Regular files
+	WalkDirs                           // Directories
+	WalkSymlinks                       // Symlinks that aren't followed
+	WalkOther                          // Devices, pipes, sockets and so on
+)
+
+// Settings for Walk and WalkStream.  The zero value lists everything, reading one directory per CPU at once.
+//
+// Patterns are matched against the path relative to the root, using / as the separator.  * and ? don't match /, but
+// ** matches any number of directories, e.g. "src/**/*.go".  A pattern without a / matches the name at any depth,
+// e.g. "*.go".
+type WalkOptions struct {
+	Include        []string  // Only list entries matching one of these.  Directories are searched either way
+	Exclude        []string  // Skip entries matching any of these, and everything inside them
+	IgnoreFiles    []string  // Names of .gitignore-style files to obey, e.g. ".gitignore"
+	MaxDepth       int       // 1 lists the root's entries, 2 their entries too, and so on.  0 means no limit
+	FollowSymlinks bool      // Treat symlinks as what they point to.  Loops are reported as errors
+	Types          WalkTypes // Only list these kinds of entries, e.g. WalkFiles|WalkDirs.  0 lists everything
+	Workers        int       // How many directories to read at once.  Default is the number of CPUs
+}
+
+// A file or directory found by Walk, or an error
+type WalkEntry struct {
+	Path  string      // The root joined with Rel
+	Rel   string      // The path relative to the root, with / as the separator
+	Depth int         // 1 for the root's entries, 2 for their entries, and so on
+	Info  os.FileInfo // From Lstat, or Stat for a followed symlink
+	Err   error       // If this is set, Path couldn't be read, and Info may be nil
+}
+
+// List everything under root (but not root itself), sorted by path.  Walk keeps going when it can't read something,
+// and returns all the errors it found.
+func Walk(root string, opts WalkOptions) ([]WalkEntry, []error) {
+	entries := []WalkEntry{}
+	errs := []error{}
+	for e := range WalkStream(context.Background(), root, opts) {
+		if e.Err != nil {
+			errs = append(errs, e.Err)
+		} else {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Rel < entries[j].Rel })
+	return entries, errs
+}
+
+// Like Walk, but sends the entries as they are found, in no particular order.  Errors are sent as entries with Err set.
+// The channel is closed when the walk finishes, or ctx is cancelled.
+func WalkStream(ctx context.Context, root string, opts WalkOptions) <-chan WalkEntry {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	w := &walker{
+		ctx:  ctx,
+		opts: opts,
+		out:  make(chan WalkEntry, 100),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	go func() {
+		defer close(w.out)
+		info, err := os.Stat(root)
+		if err != nil {
+			w.send(WalkEntry{Path: root, Err: WrapErrorf(err, "could not read %v", root)})
+			return
+		}
+		if !info.IsDir() {
+			w.send(WalkEntry{Path: root, Info: info, Err: NewErrorf("%v is not a directory", root)})
+			return
+		}
+		w.push(walkDirJob{dir: root, ancestors: []os.FileInfo{info}})
+		var wg sync.WaitGroup
+		for i := 0; i < opts.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					job, ok := w.next()
+					if !ok {
+						return
+					}
+					//Once cancelled, just empty the queue
+					if w.ctx.Err() == nil {
+						w.walkDir(job)
+					}
+					w.finished()
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+	return w.out
+}
+
+type walker struct {
+	ctx  context.Context
+	opts WalkOptions
+	out  chan WalkEntry
+
+	//Directories waiting to be read.  pending also counts the ones being read, which may add more.
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []walkDirJob
+	pending int
+}
+
+// A directory to list
+type walkDirJob struct {
+	dir, rel  string
+	depth     int
+	ancestors []os.FileInfo // For finding symlink loops
+	rules     []ignoreRule
+}
+
+func (w *walker) push(job walkDirJob) {
+	w.mu.Lock()
+	w.queue = append(w.queue, job)
+	w.pending++
+	w.mu.Unlock()
+	w.cond.Signal()
+}
+
+// Take a directory from the queue, waiting while it's empty but others are still being read.  Returns false when
+// there's nothing left to do.  The newest directory is taken first, which keeps the queue short on deep trees.
+func (w *walker) next() (walkDirJob, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.queue) == 0 && w.pending > 0 {
+		w.cond.Wait()
+	}
+	if len(w.queue) == 0 {
+		return walkDirJob{}, false
+	}
+	job := w.queue[len(w.queue)-1]
+	w.queue = w.queue[:len(w.queue)-1]
+	return job, true
+}
+
+// Mark a directory from next as done
+func (w *walker) finished() {
+	w.mu.Lock()
+	w.pending--
+	done := w.pending == 0
+	w.mu.Unlock()
+	if done {
+		w.cond.Broadcast()
+	}
+}
+
+func (w *walker) send(e WalkEntry) bool {
+	select {
+	case w.out <- e:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+// List one directory, and queue its subdirectories
+func (w *walker) walkDir(job walkDirJob) {
+	dir, rel, depth, ancestors := job.dir, job.rel, job.depth, job.ancestors
+	infos, err := ioutil.ReadDir(dir)
+	rules := job.rules
+	if err == nil {
+		rules, err = w.readIgnoreFiles(dir, rel, rules)
+	}
+	if err != nil {
+		w.send(WalkEntry{Path: dir, Rel: rel, Depth: depth, Err: WrapErrorf(err, "could not read %v", dir)})
+		return
+	}
+
+	for _, info := range infos {
+		childPath := filepath.Join(dir, info.Name())
+		childRel := path.Join(rel, info.Name())
+		link := info.Mode()&os.ModeSymlink != 0
+		if link && w.opts.FollowSymlinks {
+			target, err := os.Stat(childPath)
+			if err != nil {
+				if !w.send(WalkEntry{Path: childPath, Rel: childRel, Depth: depth + 1, Info: info, Err: WrapErrorf(err, "could not follow %v", childPath)}) {
+					return
+				}
+				continue
+			}
+			info = target
+		}
+		isDir := info.IsDir()
+		if ignored(rules, childRel, isDir) || matchesAny(w.opts.Exclude, childRel) {
+			continue
+		}
+
+		if w.wanted(info) && (len(w.opts.Include) == 0 || matchesAny(w.opts.Include, childRel)) {
+			if !w.send(WalkEntry{Path: childPath, Rel: childRel, Depth: depth + 1, Info: info}) {
+				return
+			}
+		}
+
+		if !isDir || w.opts.MaxDepth > 0 && depth+1 >= w.opts.MaxDepth {
+			continue
+		}
+		if link && inAncestors(ancestors, info) {
+			if !w.send(WalkEntry{Path: childPath, Rel: childRel, Depth: depth + 1, Info: info, Err: NewErrorf("symlink loop at %v", childPath)}) {
+				return
+			}
+			continue
+		}
+		w.push(walkDirJob{dir: childPath, rel: childRel, depth: depth + 1, ancestors: append(ancestors[:len(ancestors):len(ancestors)], info), rules: rules})
+	}
+}
+
+func inAncestors(ancestors []os.FileInfo, info os.FileInfo) bool {
+	for _, a := range ancestors {
+		if os.SameFile(a, info) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is this one of the types we are listing?
+func (w *walker) wanted(info os.FileInfo) bool {
+	if w.opts.Types == 0 {
+		return true
+	}
+	mode := info.Mode()
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return w.opts.Types&WalkSymlinks != 0
+	case mode.IsDir():
+		return w.opts.Types&WalkDirs != 0
+	case mode.IsRegular():
+		return w.opts.Types&WalkFiles != 0
+	}
+	return w.opts.Types&WalkOther != 0
+}
+
+func matchesAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if strings.Contains(p, "/") {
+			if matchGlob(strings.TrimPrefix(p, "/"), rel) {
+				return true
+			}
+		} else if ok, _ := path.Match(p, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Match a slash-separated path against a pattern, where ** matches any number of directories
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchSegments(pattern[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// One line from an ignore file
+type ignoreRule struct {
+	base     string // The directory holding the ignore file, relative to the root
+	pattern  string
+	negate   bool // Starts with !, so files that match are not ignored
+	dirOnly  bool // Ends with /, so it only matches directories
+	anchored bool // Has a / before the end, so it matches from base, not at any depth
+}
+
+// Add the rules from the ignore files in dir to rules
+func (w *walker) readIgnoreFiles(dir, rel string, rules []ignoreRule) ([]ignoreRule, error) {
+	rules = rules[:len(rules):len(rules)] //Subdirectories share the parent's rules, so don't append in place
+	for _, name := range w.opts.IgnoreFiles {
+		f, err := os.Open(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return rules, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if r, ok := parseIgnoreLine(rel, scanner.Text()); ok {
+				rules = append(rules, r)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return rules, err
+		}
+	}
+	return rules, nil
+}
+
+func parseIgnoreLine(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+	r := ignoreRule{base: base}
+	if line[0] == '!' {
+		r.negate, line = true, line[1:]
+	} else if line[0] == '\\' {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly, line = true, strings.TrimSuffix(line, "/")
+	}
+	r.anchored = strings.Contains(line, "/")
+	r.pattern = strings.TrimPrefix(line, "/")
+	return r, r.pattern != ""
+}
+
+// Should rel be ignored?  Like git, the last rule that matches wins.
+func ignored(rules []ignoreRule, rel string, isDir bool) bool {
+	result := false
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			sub = rel[len(r.base)+1:]
+		}
+		var match bool
+		if r.anchored {
+			match = matchGlob(r.pattern, sub)
+		} else {
+			match, _ = path.Match(r.pattern, path.Base(sub))
+		}
+		if match {
+			result = !r.negate
+		}
+	}
+	return result
+}
@@ -0,0 +1,259 @@
+package goof
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What happened to a watched file.  Several can be combined in one event.
+type WatchOp uint32
+
+const (
+	WatchCreate   WatchOp = 1 << iota // A new file or directory
+	WatchWrite                        // The contents changed
+	WatchRemove                       // The file was deleted
+	WatchRename                       // The file was moved away.  It may turn up again as a WatchCreate
+	WatchChmod                        // The permissions, owner or timestamps changed
+	WatchOverflow                     // Too many events happened at once, and some were lost.  Path is empty
+)
+
+func (op WatchOp) String() string {
+	names := []string{}
+	for i, name := range []string{"CREATE", "WRITE", "REMOVE", "RENAME", "CHMOD", "OVERFLOW"} {
+		if op&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// A change to a watched file or directory
+type WatchEvent struct {
+	Path string
+	Op   WatchOp
+}
+
+// Settings for Watch.  The zero value watches only the given paths (and the entries of directories), and sends events
+// as soon as they happen.
+type WatchOptions struct {
+	Recursive    bool          // Watch subdirectories too, including ones created later
+	Debounce     time.Duration // Wait until a file has been quiet this long, then send one event with all the changes
+	Poll         bool          // Check for changes by scanning, even where the system can report them, e.g. for network drives
+	PollInterval time.Duration // How often to scan, when polling.  Default 1 second
+}
+
+// Reports changes to files and directories.  Create one with Watch.
+type Watcher struct {
+	opts   WatchOptions
+	events chan WatchEvent
+	raw    chan WatchEvent // From the platform code, before debouncing
+	done   chan struct{}
+	closed sync.Once
+	stop   func() // Stops the platform code
+	err    error
+}
+
+// Watch files and directories for changes.  On Linux this uses inotify.  Elsewhere, or if opts.Poll is set, the paths
+// are scanned every opts.PollInterval, which can't see renames (they look like a remove and a create).
+//
+// Call Close when you are finished.
+func Watch(paths []string, opts WatchOptions) (*Watcher, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	w := &Watcher{
+		opts:   opts,
+		events: make(chan WatchEvent, 100),
+		raw:    make(chan WatchEvent, 100),
+		done:   make(chan struct{}),
+	}
+	var err error
+	if opts.Poll {
+		err = w.startPolling(paths)
+	} else {
+		err = w.startNative(paths)
+	}
+	if err != nil {
+		return nil, err
+	}
+	go w.debounce()
+	return w, nil
+}
+
+// The changes.  Closed after Close is called, or if watching fails.
+func (w *Watcher) Events() <-chan WatchEvent {
+	return w.events
+}
+
+// The error that stopped the watcher, if any.  Check it once Events is closed.
+func (w *Watcher) Err() error {
+	return w.err
+}
+
+// Stop watching
+func (w *Watcher) Close() error {
+	w.closed.Do(func() {
+		close(w.done)
+		w.stop()
+	})
+	return nil
+}
+
+// Send an event from the platform code.  Returns false if the watcher is closed.
+func (w *Watcher) send(path string, op WatchOp) bool {
+	select {
+	case w.raw <- WatchEvent{Path: path, Op: op}:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// Pass events on, combining the events for each file until it has been quiet for opts.Debounce
+func (w *Watcher) debounce() {
+	defer close(w.events)
+	pending := map[string]WatchOp{}
+	lastSeen := map[string]time.Time{}
+	order := []string{}
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		select {
+		case e, ok := <-w.raw:
+			if !ok {
+				for _, p := range order {
+					if !w.deliver(WatchEvent{Path: p, Op: pending[p]}) {
+						return
+					}
+				}
+				return
+			}
+			if w.opts.Debounce <= 0 {
+				if !w.deliver(e) {
+					return
+				}
+				continue
+			}
+			if _, ok := pending[e.Path]; !ok {
+				order = append(order, e.Path)
+			}
+			pending[e.Path] |= e.Op
+			lastSeen[e.Path] = time.Now()
+			timer.Reset(w.opts.Debounce)
+		case <-timer.C:
+			now := time.Now()
+			waiting := []string{}
+			var next time.Duration
+			for _, p := range order {
+				quiet := now.Sub(lastSeen[p])
+				if quiet < w.opts.Debounce {
+					waiting = append(waiting, p)
+					if left := w.opts.Debounce - quiet; next == 0 || left < next {
+						next = left
+					}
+					continue
+				}
+				if !w.deliver(WatchEvent{Path: p, Op: pending[p]}) {
+					return
+				}
+				delete(pending, p)
+				delete(lastSeen, p)
+			}
+			order = waiting
+			if len(order) > 0 {
+				timer.Reset(next)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *Watcher) deliver(e WatchEvent) bool {
+	select {
+	case w.events <- e:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// What polling remembers about a file
+type watchState struct {
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+}
+
+// Watch by scanning the paths every opts.PollInterval
+func (w *Watcher) startPolling(paths []string) error {
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return WrapErrorf(err, "could not watch %v", p)
+		}
+	}
+	ticker := time.NewTicker(w.opts.PollInterval)
+	w.stop = ticker.Stop
+	state := w.scan(paths)
+	go func() {
+		defer close(w.raw)
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+			now := w.scan(paths)
+			for p, s := range now {
+				old, ok := state[p]
+				var op WatchOp
+				switch {
+				case !ok:
+					op = WatchCreate
+				case s.size != old.size || !s.modTime.Equal(old.modTime):
+					op = WatchWrite
+				case s.mode != old.mode:
+					op = WatchChmod
+				}
+				if op != 0 && !w.send(p, op) {
+					return
+				}
+			}
+			for p := range state {
+				if _, ok := now[p]; !ok && !w.send(p, WatchRemove) {
+					return
+				}
+			}
+			state = now
+		}
+	}()
+	return nil
+}
+
+// Look at the paths, and the contents of directories
+func (w *Watcher) scan(paths []string) map[string]watchState {
+	out := map[string]watchState{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		out[p] = watchState{info.Size(), info.ModTime(), info.Mode()}
+		if !info.IsDir() {
+			continue
+		}
+		opts := WalkOptions{MaxDepth: 1}
+		if w.opts.Recursive {
+			opts.MaxDepth = 0
+		}
+		//Files can disappear while we look, which isn't worth reporting
+		entries, _ := Walk(p, opts)
+		for _, e := range entries {
+			out[e.Path] = watchState{e.Info.Size(), e.Info.ModTime(), e.Info.Mode()}
+		}
+	}
+	return out
+}
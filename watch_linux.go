@@ -0,0 +1,176 @@
+package goof
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_DELETE |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_MOVE_SELF
+
+// Watch with inotify
+func (w *Watcher) startNative(paths []string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return WrapError(err, "could not start inotify")
+	}
+	//A non-blocking file goes through Go's poller, so closing it wakes up the reader
+	in := &inotify{w: w, fd: fd, f: os.NewFile(uintptr(fd), "inotify"), paths: map[int]string{}}
+	for _, p := range paths {
+		if err := in.addTree(p, false); err != nil {
+			in.f.Close()
+			return err
+		}
+	}
+	w.stop = func() { in.f.Close() }
+	go in.run()
+	return nil
+}
+
+type inotify struct {
+	w     *Watcher
+	fd    int
+	f     *os.File
+	paths map[int]string // Watch descriptor to path
+}
+
+// Watch path, and if it's a directory and we're recursive, its subdirectories.  If report is set, send create events
+// for what's already in them, since it may have been created before the watches were in place.
+func (in *inotify) addTree(path string, report bool) error {
+	wd, err := syscall.InotifyAddWatch(in.fd, path, inotifyMask)
+	if err != nil {
+		return WrapErrorf(err, "could not watch %v", path)
+	}
+	in.paths[wd] = path
+	if !in.w.opts.Recursive || !IsDir(path) {
+		return nil
+	}
+
+	opts := WalkOptions{}
+	if !report {
+		opts.Types = WalkDirs
+	}
+	entries, _ := Walk(path, opts)
+	for _, e := range entries {
+		if e.Info.IsDir() {
+			//It might have gone already
+			if wd, err := syscall.InotifyAddWatch(in.fd, e.Path, inotifyMask); err == nil {
+				in.paths[wd] = e.Path
+			}
+		}
+		if report && !in.w.send(e.Path, WatchCreate) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (in *inotify) run() {
+	defer close(in.w.raw)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := in.f.Read(buf)
+		if err != nil {
+			select {
+			case <-in.w.done:
+			default:
+				in.w.err = WrapError(err, "could not read inotify events")
+			}
+			return
+		}
+		for pos := 0; pos+syscall.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.LittleEndian.Uint32(buf[pos:])))
+			mask := binary.LittleEndian.Uint32(buf[pos+4:])
+			nameLen := int(binary.LittleEndian.Uint32(buf[pos+12:]))
+			name := string(buf[pos+syscall.SizeofInotifyEvent : pos+syscall.SizeofInotifyEvent+nameLen])
+			pos += syscall.SizeofInotifyEvent + nameLen
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			if !in.handle(wd, mask, name) {
+				return
+			}
+		}
+	}
+}
+
+// Turn one inotify event into a WatchEvent.  Returns false if the watcher is closed.
+func (in *inotify) handle(wd int, mask uint32, name string) bool {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		return in.w.send("", WatchOverflow)
+	}
+	dir, ok := in.paths[wd]
+	if !ok {
+		return true
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(in.paths, wd)
+		return true
+	}
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+
+	var op WatchOp
+	if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		op |= WatchCreate
+	}
+	if mask&syscall.IN_MODIFY != 0 {
+		op |= WatchWrite
+	}
+	if mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF) != 0 {
+		op |= WatchRemove
+	}
+	if mask&(syscall.IN_MOVED_FROM|syscall.IN_MOVE_SELF) != 0 {
+		op |= WatchRename
+	}
+	if mask&syscall.IN_ATTRIB != 0 {
+		op |= WatchChmod
+	}
+
+	//The parent reports the same event, if it's watched
+	parentWatched := name == "" && in.watched(filepath.Dir(dir))
+
+	//A directory that moves takes its watches with it, so our paths for it are wrong now.  If its new home is
+	//watched, it gets new watches from the create event there.
+	if mask&syscall.IN_MOVED_FROM != 0 && mask&syscall.IN_ISDIR != 0 {
+		in.unwatchTree(path)
+	}
+	if mask&syscall.IN_MOVE_SELF != 0 && !parentWatched {
+		in.unwatchTree(dir)
+	}
+
+	if parentWatched {
+		return true
+	}
+	if !in.w.send(path, op) {
+		return false
+	}
+	if in.w.opts.Recursive && mask&syscall.IN_ISDIR != 0 && op&WatchCreate != 0 {
+		in.addTree(path, true)
+	}
+	return true
+}
+
+// Stop watching path and everything under it
+func (in *inotify) unwatchTree(path string) {
+	for wd, p := range in.paths {
+		if p == path || strings.HasPrefix(p, path+string(filepath.Separator)) {
+			syscall.InotifyRmWatch(in.fd, uint32(wd))
+			delete(in.paths, wd)
+		}
+	}
+}
+
+func (in *inotify) watched(path string) bool {
+	for _, p := range in.paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package goof
+
+// There's no native watcher for this platform yet, so poll
+func (w *Watcher) startNative(paths []string) error {
+	return w.startPolling(paths)
+}